@@ -0,0 +1,107 @@
+// Copyright 2025 Matteo Redaelli
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// configCheck implements "goloc config check": it loads the embedded and
+// the user config files and lists the errors and the conflicts. It returns
+// the exit status, 1 if there are errors
+func configCheck(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("config check", flag.ExitOnError)
+	configFile := flags.String("config", "", "languages config file merged over the embedded one")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s config check [options]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	config, err := LoadEmbeddedConfig()
+	if err != nil {
+		fmt.Fprintf(out, "error: %v\n", err)
+		return 1
+	}
+	var errs []string
+	for _, name := range sortedKeys(config.Languages) {
+		if err := validateLanguage(config.Languages[name]); err != nil {
+			errs = append(errs, fmt.Sprintf("embedded config.json: language '%s': %v", name, err))
+		}
+	}
+	paths := UserConfigFiles(*configFile)
+	for _, path := range paths {
+		if err := config.mergeFile(path); err != nil {
+			// errors.Join puts one error per line
+			errs = append(errs, strings.Split(err.Error(), "\n")...)
+		}
+	}
+	config.buildIndexes()
+	// rules of disabled languages are skipped by findLanguageByHeuristics
+	var warnings []string
+	for _, ext := range sortedKeys(config.Heuristics) {
+		for i, rule := range config.Heuristics[ext] {
+			if _, ok := config.Languages[rule.Language]; !ok {
+				warnings = append(warnings, fmt.Sprintf("heuristics '%s'[%d]: unknown or disabled language '%s'", ext, i, rule.Language))
+			}
+		}
+	}
+
+	for _, path := range paths {
+		fmt.Fprintf(out, "config: %s\n", path)
+	}
+	for _, e := range errs {
+		fmt.Fprintf(out, "error: %s\n", e)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(out, "warning: %s\n", w)
+	}
+	unresolved := 0
+	for _, c := range config.Conflicts {
+		var result string
+		switch config.resolution(c) {
+		case "heuristics":
+			result = fmt.Sprintf("resolved by heuristics, default '%s'", c.Languages[0])
+		case "priority":
+			result = fmt.Sprintf("resolved by priority: '%s'", c.Languages[0])
+		default:
+			result = fmt.Sprintf("unresolved: '%s' chosen by name, set a priority", c.Languages[0])
+			unresolved++
+		}
+		fmt.Fprintf(out, "conflict: %s '%s' claimed by %s: %s\n",
+			c.Kind, c.Name, strings.Join(c.Languages, ", "), result)
+	}
+	fmt.Fprintf(out, "%d languages, %d errors, %d warnings, %d conflicts (%d unresolved)\n",
+		len(config.Languages), len(errs), len(warnings), len(config.Conflicts), unresolved)
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
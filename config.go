@@ -15,75 +15,740 @@
 package main
 
 import (
+	"bytes"
 	_ "embed"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
-	//	"os"
+
+	"github.com/rs/zerolog/log"
 )
 
 //go:embed config.json
 var configData []byte
 
 type LanguageConfig struct {
+	Name              string     `json:"name,omitempty"`
+	Kind              string     `json:"kind,omitempty"` // html or markdown: hosts embedded languages
+	Mime              []string   `json:"mime,omitempty"`
 	Quotes            [][]string `json:"quotes,omitempty"`
+	// raw strings, without escapes: a pair with hashes (r#" "#) matches
+	// any number of hashes
+	VerbatimQuotes    [][]string `json:"verbatim_quotes,omitempty"`
 	MultilineComments [][]string `json:"multi_line_comments,omitempty"`
+	NestedComments    [][]string `json:"nested_comments,omitempty"`
+	Nested            bool       `json:"nested,omitempty"`
 	SingleComments    []string `json:"line_comment,omitempty"`
 	MultilineStrings  [][]string `json:"doc_quotes,omitempty"`
+	// documentation comments are counted as docs, as the doc_quotes
+	// strings starting a line (python docstrings)
+	DocComments          []string   `json:"doc_line_comment,omitempty"`
+	DocMultilineComments [][]string `json:"doc_multi_line_comments,omitempty"`
+	// literate files are prose (counted as comments) with code regions
+	// between literate_code delimiters or on lines with literate_prefixes
+	Literate          bool       `json:"literate,omitempty"`
+	LiterateCode      [][]string `json:"literate_code,omitempty"`
+	LiteratePrefixes  []string   `json:"literate_prefixes,omitempty"`
 	Extensions        []string `json:"extensions"`
+	// exact file names or glob patterns (Dockerfile.*), case sensitive
 	Filenames         []string `json:"filenames"`
+	Shebangs          []string `json:"shebangs,omitempty"`
+	Env               []string `json:"env,omitempty"`
+	// data formats (JSON, hex dumps...): with the BlankLanguages option
+	// all their lines are counted as blanks
+	Blank             bool     `json:"blank,omitempty"`
+	// tokens that need the full scan of a line, besides the comment and
+	// string markers
+	ImportantSyntax   []string `json:"important_syntax,omitempty"`
+	// all the tokens that need the full scan, see importantTokens
+	important         []string
+	Disabled          bool     `json:"disabled,omitempty"`
+	// extensions and filenames claimed by more languages go to the one
+	// with the highest priority
+	Priority          int      `json:"priority,omitempty"`
 }
 
 
 type Options struct {
-	CountFiles  bool
-	UnknownFiles bool
+	CountFiles    bool
+	UnknownFiles  bool
+	PerFile       bool
+	Filter        *Filter
+	MaxLineLength int
+	MaxFileSize   int64
+	SkipReport    bool
+	SniffSize     int
+	Latin1        bool
+	IgnoreCase    bool // match file names case insensitively
+	NoModelines   bool
+	Attributes    *GitAttributes
+	MixedLines    string // MixedCode or MixedBoth
+	ShowMixed     bool
+	BlankLanguages bool
+}
+
+// attribution of the lines with both code and comments
+const (
+	MixedCode = "code" // as code, like tokei
+	MixedBoth = "both" // as code and as comment, like cloc
+)
+
+// Heuristic chooses the language of the files with an ambiguous
+// extension when the pattern matches the beginning of the file. A rule
+// without pattern always matches
+type Heuristic struct {
+	Language string `json:"language"`
+	Pattern  string `json:"pattern,omitempty"`
+	regexp   *regexp.Regexp
+}
+
+// Conflict is an extension or a filename claimed by more than one
+// language, sorted by priority. The first language is the one used
+// without heuristics
+type Conflict struct {
+	Kind      string // extension or filename
+	Name      string
+	Languages []string
+}
+
+// FilenamePattern is a glob matching the file names of a language
+type FilenamePattern struct {
+	Pattern  string
+	Language string
 }
 
 type Config struct {
 	Languages  map[string]LanguageConfig `json:"languages"`
+	Heuristics map[string][]Heuristic `json:"heuristics"`
+	Conflicts  []Conflict `json:"conflicts"`
 	Extensions map[string]string `json:"extensions"`
 	Filenames  map[string]string `json:"filenames"`
+	FoldedFilenames  map[string]string `json:"folded_filenames"` // lowercase
+	FilenamePatterns []FilenamePattern `json:"filename_patterns"`
+	Shebangs   map[string]string `json:"shebangs"`
+	Env        map[string]string `json:"env"`
+	Names      map[string]string `json:"names"`
+	Mimes      map[string]string `json:"mimes"`
 	Options    Options `json:"options"`
 }
 
 func LoadEmbeddedConfig() (*Config, error) {
-	var config Config
+	languages, err := parseLanguages(configData, "embedded config.json")
+	if err != nil {
+		return nil, err
+	}
+	heuristics, err := parseHeuristics(configData, "embedded config.json")
+	if err != nil {
+		return nil, err
+	}
+	config := Config{Languages: languages, Heuristics: heuristics}
+	config.buildIndexes()
+	return &config, nil
+}
 
-	err := json.Unmarshal(configData, &config)
+// LoadConfig merges the user config files over the embedded languages: a
+// language is added, replaced or, with "disabled": true, removed
+func LoadConfig(paths []string) (*Config, error) {
+	config, err := LoadEmbeddedConfig()
 	if err != nil {
 		return nil, err
 	}
+	for _, path := range paths {
+		if err := config.mergeFile(path); err != nil {
+			return nil, err
+		}
+	}
+	config.buildIndexes()
+	config.reportConflicts()
+	return config, nil
+}
 
-	// Initialize Extensions and Options maps before using it
+// UserConfigFiles returns the existing user config files in order of
+// precedence: ~/.config/goloc/languages.json, ./.goloc.json and the one
+// given on the command line
+func UserConfigFiles(configFile string) []string {
+	var paths []string
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configDir = filepath.Join(home, ".config")
+		}
+	}
+	candidates := []string{".goloc.json"}
+	if configDir != "" {
+		candidates = append([]string{filepath.Join(configDir, "goloc", "languages.json")}, candidates...)
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if configFile != "" {
+		paths = append(paths, configFile)
+	}
+	return paths
+}
+
+func parseLanguages(data []byte, source string) (map[string]LanguageConfig, error) {
+	var raw struct {
+		Languages map[string]json.RawMessage `json:"languages"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	languages := make(map[string]LanguageConfig)
+	for name, value := range raw.Languages {
+		var lang LanguageConfig
+		if err := json.Unmarshal(value, &lang); err != nil {
+			return nil, fmt.Errorf("%s: language '%s': %w", source, name, err)
+		}
+		// tokens in config.json are stored with escaped quotes (\" for ")
+		lang.Quotes = unescapePairs(lang.Quotes)
+		lang.VerbatimQuotes = unescapePairs(lang.VerbatimQuotes)
+		lang.MultilineComments = unescapePairs(lang.MultilineComments)
+		lang.NestedComments = unescapePairs(lang.NestedComments)
+		lang.MultilineStrings = unescapePairs(lang.MultilineStrings)
+		lang.SingleComments = unescapeTokens(lang.SingleComments)
+		lang.DocMultilineComments = unescapePairs(lang.DocMultilineComments)
+		lang.DocComments = unescapeTokens(lang.DocComments)
+		lang.ImportantSyntax = unescapeTokens(lang.ImportantSyntax)
+		lang.important = importantTokens(lang)
+		languages[name] = lang
+	}
+	return languages, nil
+}
+
+// parseHeuristics reads the heuristics of the ambiguous extensions
+func parseHeuristics(data []byte, source string) (map[string][]Heuristic, error) {
+	var raw struct {
+		Heuristics map[string][]Heuristic `json:"heuristics"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	heuristics := make(map[string][]Heuristic)
+	for ext, rules := range raw.Heuristics {
+		for i := range rules {
+			if rules[i].Language == "" {
+				return nil, fmt.Errorf("%s: heuristics '%s'[%d]: no language", source, ext, i)
+			}
+			if rules[i].Pattern == "" {
+				continue
+			}
+			re, err := regexp.Compile("(?m)" + rules[i].Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: heuristics '%s'[%d]: %w", source, ext, i, err)
+			}
+			rules[i].regexp = re
+		}
+		heuristics[strings.ToLower(ext)] = rules
+	}
+	return heuristics, nil
+}
+
+func (config *Config) mergeFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	languages, err := parseLanguages(data, path)
+	if err != nil {
+		return err
+	}
+	heuristics, err := parseHeuristics(data, path)
+	if err != nil {
+		return err
+	}
+	// the heuristics of an extension replace the embedded ones
+	for ext, rules := range heuristics {
+		config.Heuristics[ext] = rules
+	}
+
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		lang := languages[name]
+		if lang.Disabled {
+			log.Debug().Msgf("%s: language '%s' disabled", path, name)
+			delete(config.Languages, name)
+			continue
+		}
+		if err := validateLanguage(lang); err != nil {
+			errs = append(errs, fmt.Errorf("%s: language '%s': %w", path, name, err))
+			continue
+		}
+		log.Debug().Msgf("%s: language '%s' loaded", path, name)
+		config.Languages[name] = lang
+	}
+	return errors.Join(errs...)
+}
+
+func validateLanguage(lang LanguageConfig) error {
+	if len(lang.Extensions) == 0 && len(lang.Filenames) == 0 && len(lang.Shebangs) == 0 && len(lang.Env) == 0 {
+		return errors.New("no extensions, filenames, shebangs or env")
+	}
+	pairs := map[string][][]string{
+		"quotes":              lang.Quotes,
+		"verbatim_quotes":     lang.VerbatimQuotes,
+		"multi_line_comments": lang.MultilineComments,
+		"nested_comments":     lang.NestedComments,
+		"doc_quotes":          lang.MultilineStrings,
+		"literate_code":       lang.LiterateCode,
+		"doc_multi_line_comments": lang.DocMultilineComments,
+	}
+	for _, key := range []string{"quotes", "verbatim_quotes", "multi_line_comments", "nested_comments", "doc_quotes", "literate_code", "doc_multi_line_comments"} {
+		for i, pair := range pairs[key] {
+			if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+				return fmt.Errorf("%s[%d]: expected a pair of non empty start and end strings", key, i)
+			}
+		}
+	}
+	for i, file := range lang.Filenames {
+		if _, err := path.Match(file, ""); err != nil {
+			return fmt.Errorf("filenames[%d]: %w", i, err)
+		}
+	}
+	for i, comment := range lang.SingleComments {
+		if comment == "" {
+			return fmt.Errorf("line_comment[%d]: empty string", i)
+		}
+	}
+	for i, comment := range lang.DocComments {
+		if comment == "" {
+			return fmt.Errorf("doc_line_comment[%d]: empty string", i)
+		}
+	}
+	for i, token := range lang.ImportantSyntax {
+		if token == "" {
+			return fmt.Errorf("important_syntax[%d]: empty string", i)
+		}
+	}
+	return nil
+}
+
+// importantTokens returns the tokens that need the full scan of a line:
+// the important_syntax and the start of comments and strings. Lines
+// without any of them are code
+func importantTokens(lang LanguageConfig) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	add := func(token string) {
+		if token != "" && !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	for _, token := range lang.ImportantSyntax {
+		add(token)
+	}
+	for _, token := range lang.SingleComments {
+		add(token)
+	}
+	for _, token := range lang.DocComments {
+		add(token)
+	}
+	for _, pairs := range [][][]string{lang.Quotes, lang.VerbatimQuotes, lang.MultilineComments,
+		lang.NestedComments, lang.DocMultilineComments, lang.MultilineStrings} {
+		for _, pair := range pairs {
+			if len(pair) > 0 {
+				add(pair[0])
+			}
+		}
+	}
+	for _, pair := range lang.VerbatimQuotes {
+		// r###" is matched by r#" too, see raw_string_delimiters
+		if hash := strings.LastIndexByte(pair[0], '#'); hash >= 0 {
+			add(pair[0][hash:])
+		}
+	}
+	return tokens
+}
+
+// buildIndexes maps extensions, filenames, shebangs, env, names and mime
+// types to languages. Languages are visited by priority and name, so that
+// an extension or a filename claimed by more languages goes to the first one
+func (config *Config) buildIndexes() {
 	config.Extensions = make(map[string]string)
 	config.Filenames = make(map[string]string)
-	// move extensions
-	for lang, value := range config.Languages {
-		for _, ext := range value.Extensions {	
-			config.Extensions[ext] = lang
+	config.FoldedFilenames = make(map[string]string)
+	config.FilenamePatterns = nil
+	config.Shebangs = make(map[string]string)
+	config.Env = make(map[string]string)
+	config.Names = make(map[string]string)
+	config.Mimes = make(map[string]string)
+	claims := map[string]map[string][]string{"extension": {}, "filename": {}}
+	claim := func(kind string, index map[string]string, name string, lang string) {
+		languages := claims[kind][name]
+		if len(languages) > 0 && languages[len(languages)-1] == lang {
+			return // listed twice by the same language
+		}
+		claims[kind][name] = append(languages, lang)
+		if _, ok := index[name]; !ok {
+			index[name] = lang
+		}
+	}
+
+	names := make([]string, 0, len(config.Languages))
+	for lang := range config.Languages {
+		names = append(names, lang)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := config.Languages[names[i]].Priority, config.Languages[names[j]].Priority
+		if pi != pj {
+			return pi > pj
+		}
+		return names[i] < names[j]
+	})
+
+	for _, lang := range names {
+		value := config.Languages[lang]
+		// findLanguage looks up lowercase extensions
+		for _, ext := range value.Extensions {
+			claim("extension", config.Extensions, strings.ToLower(ext), lang)
 		}
 		for _, file := range value.Filenames {
-			config.Filenames[file] = lang
+			if strings.ContainsAny(file, "*?[") {
+				claim("filename", map[string]string{}, file, lang)
+				config.FilenamePatterns = append(config.FilenamePatterns, FilenamePattern{file, lang})
+				continue
+			}
+			claim("filename", config.Filenames, file, lang)
+			if _, ok := config.FoldedFilenames[strings.ToLower(file)]; !ok {
+				config.FoldedFilenames[strings.ToLower(file)] = lang
+			}
+		}
+		for _, shebang := range value.Shebangs {
+			config.Shebangs[shebang] = lang
+		}
+		for _, env := range value.Env {
+			config.Env[env] = lang
+		}
+		for _, mime := range value.Mime {
+			config.Mimes[mime] = lang
+		}
+		config.Names[strings.ToLower(lang)] = lang
+		if value.Name != "" {
+			config.Names[strings.ToLower(value.Name)] = lang
 		}
 	}
-	return &config, nil
+
+	config.Conflicts = nil
+	for _, kind := range []string{"extension", "filename"} {
+		for name, languages := range claims[kind] {
+			if len(languages) > 1 {
+				config.Conflicts = append(config.Conflicts, Conflict{kind, name, languages})
+			}
+		}
+	}
+	sort.Slice(config.Conflicts, func(i, j int) bool {
+		a, b := config.Conflicts[i], config.Conflicts[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+}
+
+// resolution tells how a conflict is resolved: "heuristics", "priority"
+// or "" when the first language is chosen by name
+func (config *Config) resolution(c Conflict) string {
+	if _, ok := config.Heuristics[c.Name]; ok && c.Kind == "extension" {
+		return "heuristics"
+	}
+	if config.Languages[c.Languages[0]].Priority > config.Languages[c.Languages[1]].Priority {
+		return "priority"
+	}
+	return ""
+}
+
+// reportConflicts logs the extensions and filenames claimed by more
+// languages, warning for the ones resolved by name only
+func (config *Config) reportConflicts() {
+	for _, c := range config.Conflicts {
+		if how := config.resolution(c); how != "" {
+			log.Debug().Msgf("%s '%s' claimed by %v: chosen by %s", c.Kind, c.Name, c.Languages, how)
+			continue
+		}
+		log.Warn().Msgf("%s '%s' claimed by %v: '%s' is used", c.Kind, c.Name, c.Languages, c.Languages[0])
+	}
+}
+
+// detection is the file whose language is being detected: its beginning
+// is read once, by the first detector needing it, unless the file has
+// already been opened by the parser
+type detection struct {
+	path   string
+	config Config
+	file   *os.File
+	head   []byte
+	err    error
+	read   bool
+}
+
+func (d *detection) readHead() ([]byte, error) {
+	if !d.read {
+		d.head, d.err = readHead(d.path, heuristicsSize)
+		d.read = true
+	}
+	return d.head, d.err
 }
 
-func findLanguage(filename string, config Config) (string, error) {
-	filename = strings.ToLower(filepath.Base(filename)) // Windows system
-	ext := filepath.Ext(filename)
+type languageDetector struct {
+	name   string
+	detect func(d *detection) (string, bool)
+}
+
+// detectors are tried in order, the first one telling the language wins.
+// The file name is checked before the extension, so that CMakeLists.txt
+// is not taken for text, and ambiguous extensions are left to the shebang
+// and then to the heuristics
+var detectors = []languageDetector{
+	{"attributes", findLanguageByAttributes},
+	{"modeline", findLanguageByModeline},
+	{"filename", findLanguageByFilename},
+	{"extension", findLanguageByExtension},
+	{"shebang", findLanguageByShebang},
+	{"heuristics", findLanguageByHeuristics},
+}
+
+// findLanguage returns the language of the file and the name of the
+// detector telling it. A not nil file is the open file and head its
+// first bytes, so that the detectors do not open it again
+func findLanguage(path string, config Config, file *os.File, head []byte) (string, string, error) {
+	d := &detection{path: path, config: config, file: file, head: head, read: file != nil}
+	for _, detector := range detectors {
+		if lang, ok := detector.detect(d); ok {
+			return lang, detector.name, nil
+		}
+	}
+	ext := filepath.Ext(strings.ToLower(filepath.Base(path))) // Windows system
 	if len(ext) > 1 {
 		ext = ext[1:] // removes the dot
 	}
-	if lang, ok := config.Extensions[ext]; ok {
-		return lang, nil
-	} else {
-		if lang, ok := config.Filenames[filename]; ok {
-			return lang, nil
-		} else {
-			return ext, errors.New("unknown_extension_or_filename")
+	return ext, "", errors.New("unknown_extension_or_filename")
+}
+
+// findLanguageByAttributes honours the linguist-language attribute of the
+// .gitattributes files
+func findLanguageByAttributes(d *detection) (string, bool) {
+	value, ok := d.config.Options.Attributes.language(d.path)
+	if !ok || value == "" {
+		return "", false
+	}
+	// linguist writes the spaces of the names as dashes
+	for _, name := range []string{value, strings.ReplaceAll(value, "-", " ")} {
+		if lang := embeddedLanguage(name, d.config); lang != "" {
+			return lang, true
+		}
+	}
+	log.Debug().Msgf("file '%s': unknown linguist-language '%s'", d.path, value)
+	return "", false
+}
+
+// modelines are searched in the first and last lines of a file, as vim does
+const modelineLines = 5
+
+// vimOption is an option of a vim modeline preceding the file type: a
+// "name=value" or a boolean option ("et", "noai")
+const vimOption = `(?:\w+=[^\s:]*|(?:no)?(?:ai|autoindent|bin|binary|bomb|ci|cindent|eol|et|expandtab|fixeol|list|ma|modifiable|ro|readonly|si|smartindent|spell|wrap))`
+
+var (
+	// "vim: ft=python", "vim: set ts=4 et ft=python:", without free text
+	// between "vim:" and the options
+	vimModeline   = regexp.MustCompile(`(?:^|\s)(?:vi|[Vv]im(?:[<=>]?\d+)?|ex):\s*(?:set?\s+)?(?:` + vimOption + `[\s:]+)*(?:ft|filetype|syntax)=([\w+-]+)`)
+	emacsModeline = regexp.MustCompile(`-\*-(.*?)-\*-`)
+	emacsMode     = regexp.MustCompile(`(?i)(?:^|;)\s*mode:\s*([\w+-]+)`)
+)
+
+// findLanguageByModeline honours the vim (vim: ft=python) and emacs
+// (-*- mode: ruby -*-) modelines
+func findLanguageByModeline(d *detection) (string, bool) {
+	if d.config.Options.NoModelines || d.config.Options.CountFiles {
+		// counting files does not read them
+		return "", false
+	}
+	head, err := d.readHead()
+	if err != nil {
+		return "", false
+	}
+	lines := strings.Split(string(head), "\n")
+	n := min(len(lines), modelineLines)
+	candidates := lines[:n:n]
+	if len(head) < heuristicsSize {
+		// the whole file has been read, the last line may be empty
+		candidates = append(candidates, lines[max(len(lines)-modelineLines-1, 0):]...)
+	} else if d.file != nil {
+		if tail, err := readTail(d.file, 1024); err == nil {
+			lines = strings.Split(string(tail), "\n")
+			candidates = append(candidates, lines[max(len(lines)-modelineLines-1, 0):]...)
+		}
+	}
+	for _, line := range candidates {
+		if mode := modelineMode(line); mode != "" {
+			if lang := embeddedLanguage(mode, d.config); lang != "" {
+				return lang, true
+			}
 		}
 	}
+	return "", false
+}
+
+// modelineMode returns the file type of a vim or emacs modeline
+func modelineMode(line string) string {
+	if m := vimModeline.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	if m := emacsModeline.FindStringSubmatch(line); m != nil {
+		if !strings.Contains(m[1], ":") {
+			return strings.TrimSpace(m[1]) // -*- ruby -*-
+		}
+		if mode := emacsMode.FindStringSubmatch(m[1]); mode != nil {
+			return mode[1]
+		}
+	}
+	return ""
+}
+
+// findLanguageByFilename looks up the file names and then the glob
+// patterns, case insensitively with the IgnoreCase option
+func findLanguageByFilename(d *detection) (string, bool) {
+	name := filepath.Base(d.path)
+	config := d.config
+	ignoreCase := config.Options.IgnoreCase
+	index := config.Filenames
+	if ignoreCase {
+		index = config.FoldedFilenames
+		name = strings.ToLower(name)
+	}
+	if lang, ok := index[name]; ok {
+		return lang, true
+	}
+	for _, p := range config.FilenamePatterns {
+		pattern := p.Pattern
+		if ignoreCase {
+			pattern = strings.ToLower(pattern)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return p.Language, true
+		}
+	}
+	return "", false
+}
+
+// findLanguageByExtension looks up the extension of the file, unless it is
+// ambiguous (it has heuristics)
+func findLanguageByExtension(d *detection) (string, bool) {
+	lang, ext, ok := matchExtension(d.path, d.config)
+	if !ok {
+		return "", false
+	}
+	if _, ambiguous := d.config.Heuristics[ext]; ambiguous {
+		return "", false
+	}
+	return lang, true
+}
+
+// matchExtension looks up the extensions of the file from the longest
+// one: "d.ts" is tried before "ts" for "index.d.ts"
+func matchExtension(path string, config Config) (string, string, bool) {
+	filename := strings.ToLower(filepath.Base(path))
+	for i := 0; i < len(filename); i++ {
+		if filename[i] != '.' {
+			continue
+		}
+		ext := filename[i+1:]
+		if lang, ok := config.Extensions[ext]; ok {
+			return lang, ext, true
+		}
+	}
+	return "", "", false
+}
+
+// findLanguageByHeuristics chooses the language of a file with an
+// ambiguous extension by the first heuristic matching its content, or
+// returns the language of the extension
+func findLanguageByHeuristics(d *detection) (string, bool) {
+	lang, ext, ok := matchExtension(d.path, d.config)
+	if !ok {
+		return "", false
+	}
+	rules, ok := d.config.Heuristics[ext]
+	if !ok {
+		return "", false
+	}
+	head, err := d.readHead()
+	if err != nil {
+		return lang, true
+	}
+	for _, rule := range rules {
+		if _, ok := d.config.Languages[rule.Language]; !ok {
+			continue // disabled by the user config
+		}
+		if rule.regexp == nil || rule.regexp.Match(head) {
+			return rule.Language, true
+		}
+	}
+	return lang, true
+}
+
+// findLanguageByShebang checks the first line of a script for a known
+// interpreter: "#!/bin/bash" or "#!/usr/bin/env python3"
+func findLanguageByShebang(d *detection) (string, bool) {
+	config := d.config
+	head, err := d.readHead()
+	if err != nil || !bytes.HasPrefix(head, []byte("#!")) {
+		return "", false
+	}
+	line, _, _ := strings.Cut(string(head), "\n")
+	line = strings.TrimSpace(line)
+	if lang, ok := config.Shebangs[line]; ok {
+		return lang, true
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return "", false
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			// skip env options (-S) and variables (VAR=value)
+			if strings.HasPrefix(field, "-") || strings.Contains(field, "=") {
+				continue
+			}
+			interpreter = filepath.Base(field)
+			break
+		}
+	}
+	lang, ok := config.Env[interpreter]
+	return lang, ok
+}
+
+func unescapeTokens(tokens []string) []string {
+	result := make([]string, len(tokens))
+	for i, token := range tokens {
+		result[i] = strings.ReplaceAll(token, `\"`, `"`)
+	}
+	return result
+}
+
+func unescapePairs(pairs [][]string) [][]string {
+	result := make([][]string, len(pairs))
+	for i, pair := range pairs {
+		result[i] = unescapeTokens(pair)
+	}
+	return result
 }
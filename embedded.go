@@ -0,0 +1,129 @@
+// Copyright 2025 Matteo Redaelli
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EmbeddedBlock is a block of a child language inside a host file: a
+// <script> or <style> element of html like languages or a fenced code
+// block of markdown. When the child language is unknown, language is ""
+// and the lines are counted for the host
+type EmbeddedBlock struct {
+	language string
+	config   LanguageConfig
+	end      string
+	block    Block
+	stats    FileStats
+}
+
+var htmlAttribute = regexp.MustCompile(`\b(lang|type)\s*=\s*["']?([^"'\s>]+)`)
+
+// findEmbeddedStart checks if the line opens an embedded block for a host
+// language of the given kind
+func findEmbeddedStart(line string, kind string, config Config) *EmbeddedBlock {
+	trimmed := strings.TrimSpace(line)
+	var embedded *EmbeddedBlock
+	switch kind {
+	case "html":
+		embedded = findHtmlElement(trimmed, "script", "JavaScript", config)
+		if embedded == nil {
+			embedded = findHtmlElement(trimmed, "style", "Css", config)
+		}
+	case "markdown":
+		embedded = findMarkdownFence(trimmed, config)
+	}
+	if embedded != nil {
+		log.Debug().Msgf("embedded '%s' block start found", embedded.language)
+		embedded.config = config.Languages[embedded.language]
+	}
+	return embedded
+}
+
+// findHtmlElement finds an element like <script lang="ts"> left open at
+// the end of the line
+func findHtmlElement(line string, tag string, defaultLanguage string, config Config) *EmbeddedBlock {
+	lower := strings.ToLower(line)
+	idx := strings.Index(lower, "<"+tag)
+	if idx < 0 {
+		return nil
+	}
+	rest := lower[idx+1+len(tag):]
+	if rest != "" && !strings.ContainsAny(rest[:1], " \t>") {
+		// another tag, like <scripts>
+		return nil
+	}
+	end := "</" + tag
+	if strings.Contains(rest, end) {
+		return nil
+	}
+
+	language := defaultLanguage
+	attributes := line[idx:]
+	if i := strings.Index(attributes, ">"); i >= 0 {
+		attributes = attributes[:i]
+	}
+	for _, m := range htmlAttribute.FindAllStringSubmatch(attributes, -1) {
+		if strings.ToLower(m[1]) == "type" && strings.ToLower(m[2]) == "module" {
+			continue
+		}
+		// an unknown lang or type (i.e. text/template) is left to the host
+		language = embeddedLanguage(m[2], config)
+	}
+	return &EmbeddedBlock{language: language, end: end}
+}
+
+// findMarkdownFence finds a fenced code block like ```python
+func findMarkdownFence(line string, config Config) *EmbeddedBlock {
+	for _, fence := range []string{"```", "~~~"} {
+		if !strings.HasPrefix(line, fence) {
+			continue
+		}
+		info := strings.TrimLeft(line, fence[:1])
+		end := line[:len(line)-len(info)]
+		name := ""
+		if fields := strings.Fields(info); len(fields) > 0 {
+			name = fields[0]
+		}
+		return &EmbeddedBlock{language: embeddedLanguage(name, config), end: end}
+	}
+	return nil
+}
+
+// isEnd checks if the line closes the embedded block
+func (e *EmbeddedBlock) isEnd(line string, kind string) bool {
+	trimmed := strings.TrimSpace(line)
+	if kind == "markdown" {
+		return strings.HasPrefix(trimmed, e.end) && strings.Trim(trimmed, e.end[:1]) == ""
+	}
+	return strings.Contains(strings.ToLower(trimmed), e.end)
+}
+
+// embeddedLanguage resolves a name used in a lang attribute, a mime type or
+// a code fence info string: a language name, an extension, a mime type or
+// an interpreter name
+func embeddedLanguage(name string, config Config) string {
+	name = strings.ToLower(strings.Trim(name, "{}."))
+	for _, index := range []map[string]string{config.Names, config.Extensions, config.Mimes, config.Env} {
+		if lang, ok := index[name]; ok {
+			return lang
+		}
+	}
+	return ""
+}
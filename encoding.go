@@ -0,0 +1,169 @@
+// Copyright 2025 Matteo Redaelli
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type Encoding int
+
+const (
+	UTF8 Encoding = iota
+	UTF16LE
+	UTF16BE
+	UTF32LE
+	UTF32BE
+	Latin1
+)
+
+func (e Encoding) String() string {
+	return [...]string{"UTF-8", "UTF-16LE", "UTF-16BE", "UTF-32LE", "UTF-32BE", "Latin-1"}[e]
+}
+
+var boms = []struct {
+	bom      []byte
+	encoding Encoding
+}{
+	// UTF-32LE first, its BOM starts with the UTF-16LE one
+	{[]byte{0xff, 0xfe, 0x00, 0x00}, UTF32LE},
+	{[]byte{0x00, 0x00, 0xfe, 0xff}, UTF32BE},
+	{[]byte{0xff, 0xfe}, UTF16LE},
+	{[]byte{0xfe, 0xff}, UTF16BE},
+	{[]byte{0xef, 0xbb, 0xbf}, UTF8},
+}
+
+// detectEncoding returns the encoding of the sniffed data and the length
+// of its byte order mark. Without a BOM, data is UTF-8 unless it is not
+// valid UTF-8 and the latin1 fallback is enabled
+func detectEncoding(data []byte, latin1 bool) (Encoding, int) {
+	for _, b := range boms {
+		if bytes.HasPrefix(data, b.bom) {
+			return b.encoding, len(b.bom)
+		}
+	}
+	if latin1 && !validUTF8Prefix(data) {
+		return Latin1, 0
+	}
+	return UTF8, 0
+}
+
+// validUTF8Prefix ignores a rune truncated by the end of the sniffed data
+func validUTF8Prefix(data []byte) bool {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				data = data[:i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(data)
+}
+
+// decodingReader converts the text read from src to UTF-8
+type decodingReader struct {
+	src      *bufio.Reader
+	encoding Encoding
+	pending  []byte
+}
+
+func newDecodingReader(src *bufio.Reader, encoding Encoding) *bufio.Reader {
+	return bufio.NewReader(&decodingReader{src: src, encoding: encoding})
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(d.pending) > 0 {
+			copied := copy(p[n:], d.pending)
+			d.pending = d.pending[copied:]
+			n += copied
+			continue
+		}
+		r, err := d.readRune()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		d.pending = utf8.AppendRune(d.pending[:0], r)
+	}
+	return n, nil
+}
+
+func (d *decodingReader) readRune() (rune, error) {
+	var buf [4]byte
+	switch d.encoding {
+	case UTF16LE, UTF16BE:
+		unit, err := d.readUnit16(buf[:2])
+		if err != nil {
+			return 0, err
+		}
+		if !utf16.IsSurrogate(unit) {
+			return unit, nil
+		}
+		// only a high surrogate followed by a low one is a pair, a lone
+		// surrogate is replaced and the next unit (e.g. "\n") is kept
+		next, err := d.src.Peek(2)
+		if unit >= 0xdc00 || err != nil {
+			return utf8.RuneError, nil
+		}
+		r := utf16.DecodeRune(unit, d.unit16(next))
+		if r == utf8.RuneError {
+			return r, nil
+		}
+		d.src.Discard(2)
+		return r, nil
+	case UTF32LE, UTF32BE:
+		if _, err := io.ReadFull(d.src, buf[:]); err != nil {
+			return 0, err
+		}
+		r := rune(binary.LittleEndian.Uint32(buf[:]))
+		if d.encoding == UTF32BE {
+			r = rune(binary.BigEndian.Uint32(buf[:]))
+		}
+		if !utf8.ValidRune(r) {
+			return utf8.RuneError, nil
+		}
+		return r, nil
+	case Latin1:
+		b, err := d.src.ReadByte()
+		return rune(b), err
+	default:
+		r, _, err := d.src.ReadRune()
+		return r, err
+	}
+}
+
+func (d *decodingReader) readUnit16(buf []byte) (rune, error) {
+	if _, err := io.ReadFull(d.src, buf); err != nil {
+		return 0, err
+	}
+	return d.unit16(buf), nil
+}
+
+func (d *decodingReader) unit16(buf []byte) rune {
+	if d.encoding == UTF16BE {
+		return rune(binary.BigEndian.Uint16(buf))
+	}
+	return rune(binary.LittleEndian.Uint16(buf))
+}
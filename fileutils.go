@@ -15,44 +15,118 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
-	"net/http"
 	"os"
 	"path/filepath"
-	"slices"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
 	"github.com/sabhiram/go-gitignore"
 )
 
-func isTextFile(filename string) bool {
+// isBinary checks a sniffed chunk of a file: binary files contain NUL
+// bytes, or many invalid UTF-8 sequences or control chars. Files with a
+// UTF-16/UTF-32 byte order mark are text
+func isBinary(data []byte) bool {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}), // UTF-16LE and UTF-32LE
+		bytes.HasPrefix(data, []byte{0xfe, 0xff}),             // UTF-16BE
+		bytes.HasPrefix(data, []byte{0x00, 0x00, 0xfe, 0xff}): // UTF-32BE
+		return false
+	}
+	data = bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf}) // UTF-8
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+
+	suspicious := 0
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			// a rune truncated by the end of the sniffed chunk is fine
+			if !utf8.FullRune(data[i:]) {
+				i = len(data)
+				continue
+			}
+			suspicious++
+		case r < 0x20 && !strings.ContainsRune("\t\n\v\f\r\x1b", r):
+			suspicious++
+		}
+		i += size
+	}
+	// more than 10% of suspicious bytes
+	return suspicious*10 > len(data)
+}
+
+// bytes read from the beginning of a file to choose its language
+const heuristicsSize = 8192
+
+// readHead reads at most n bytes from the beginning of a file
+func readHead(filename string, n int64) ([]byte, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		return false
+		return nil, err
 	}
 	defer file.Close()
 
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
+	return io.ReadAll(io.LimitReader(file, n))
+}
+
+// readTail reads at most n bytes from the end of an open file, without
+// moving its offset
+func readTail(file *os.File, n int64) ([]byte, error) {
+	info, err := file.Stat()
 	if err != nil {
-		return false
+		return nil, err
 	}
+	offset := max(info.Size()-n, 0)
+	tail := make([]byte, info.Size()-offset)
+	read, err := file.ReadAt(tail, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return tail[:read], nil
+}
 
-	contentType := http.DetectContentType(buffer[:n])
-	return strings.HasPrefix(contentType, "text/")
+// FileEntry is a file found walking the input paths. Skip is set for the
+// files that are not to be parsed, so that they can be reported
+type FileEntry struct {
+	Path  string
+	Skip  SkipReason
+	Error string
 }
 
-func removeDuplicates(input []string) []string {
-	seen := make(map[string]bool)
-	result := []string{}
+// removeNestedPaths drops the duplicated input paths and the ones inside
+// another input directory, so that each file is walked only once
+func removeNestedPaths(input []string) []string {
+	abs := make([]string, len(input))
+	dirs := make([]bool, len(input))
+	for i, path := range input {
+		abs[i], _ = filepath.Abs(path)
+		dirs[i] = dirExists(path)
+	}
 
-	for _, item := range input {
-		if !seen[item] {
-			seen[item] = true
-			result = append(result, item)
+	result := []string{}
+	for i, path := range input {
+		nested := false
+		for j := range input {
+			if j < i && abs[i] == abs[j] ||
+				dirs[j] && strings.HasPrefix(abs[i], abs[j]+string(filepath.Separator)) {
+				nested = true
+				break
+			}
+		}
+		if nested {
+			log.Info().Msgf("Skipping input file/dir '%s', already included", path)
+		} else {
+			result = append(result, path)
 		}
 	}
 	return result
@@ -60,16 +134,19 @@ func removeDuplicates(input []string) []string {
 
 func dirExists(path string) bool {
 	info, err := os.Stat(path)
-	if os.IsNotExist(err) {
+	if err != nil {
+		// missing or unreadable, reported by listFiles
 		return false
 	}
 	return info.IsDir()
 }
 
-func listFiles(paths []string) []string {
-	var result []string
+// listFiles sends to files the files given in paths and the files found
+// walking the directories, then closes the channel
+func listFiles(paths []string, filter *Filter, files chan<- FileEntry) {
+	defer close(files)
 
-	for _, path := range(paths)  {
+	for _, path := range removeNestedPaths(paths) {
 		log.Info().Msgf("Processing input file/dir '%s'", path)
 		
 		info, err := os.Stat(path)
@@ -78,58 +155,97 @@ func listFiles(paths []string) []string {
 			continue
 		}
 
+		if filter.IsExcludedArg(path, info.IsDir()) {
+			log.Info().Msgf("%s is excluded", path)
+			files <- FileEntry{Path: path, Skip: SkipFiltered}
+			continue
+		}
+
 		if info.IsDir() {
 			log.Debug().Msgf("%s is a directory\n", path)
-			files, err := listDirFiles(path)
-			if err != nil {
+			if err := listDirFiles(path, filter, files); err != nil {
 				log.Error().Msgf("%s: error: %v\n", path, err)
-			} else {
-				result = slices.Concat(result, files)
 			}
-		} else if isTextFile(path) {
-			log.Debug().Msgf("%s is a text file\n", path)
-			result = append(result, path)
+		} else if !filter.IsIncludedArg(path) {
+			log.Info().Msgf("%s is not included", path)
+			files <- FileEntry{Path: path, Skip: SkipFiltered}
 		} else {
-			log.Error().Msgf("%s is not a text file\n", path)
+			files <- FileEntry{Path: path}
 		}
 	}
-	return removeDuplicates(result)
 }
 
-func listDirFiles(root string) ([]string, error) {
-	var files []string
-	ig, err := ignore.CompileIgnoreFile(filepath.Join(root, ".gitignore"))
-	
+// listDirFiles walks root skipping the files ignored by git: nested
+// .gitignore files, .git/info/exclude and the global core.excludesFile,
+// and the files excluded or not included by filter. Files are sent to the
+// files channel as soon as they are found
+func listDirFiles(root string, filter *Filter, files chan<- FileEntry) error {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	ignores := newIgnoreStack(absRoot)
+	fixed := len(ignores)
+
 	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return err
+			// an unreadable path is reported and skipped, not to stop the walk
+			log.Warn().Msgf("'%s' skipped: %v", path, err)
+			reason := SkipReadError
+			if errors.Is(err, fs.ErrPermission) {
+				reason = SkipPermissionDenied
+			}
+			files <- FileEntry{Path: path, Skip: reason, Error: err.Error()}
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
 		}
 
 		// Compute relative path for .gitignore matching
 		relPath, _ := filepath.Rel(root, path)
+		absPath := filepath.Join(absRoot, relPath)
+		ignores = ignores.leave(absPath, fixed)
 
 		log.Debug().Msgf("path = '%s', relPath = '%s'", path, relPath)
-		
+
+		if d.IsDir() && d.Name() == ".git" {
+			log.Debug().Msgf("git dir '%s' will be skipped", path)
+			return fs.SkipDir
+		}
+
 		// Skip ignored files or directories
-		if ig != nil && ig.MatchesPath(relPath) {
+		if relPath != "." && ignores.isIgnored(absPath, d.IsDir()) {
+			log.Debug().Msgf("'%s' is ignored", path)
 			if d.IsDir() {
 				return fs.SkipDir
 			}
 			return nil
 		}
 
-		if d.IsDir() && strings.HasPrefix(relPath, ".git") {
-			log.Debug().Msgf("git dir '%s' will be skipped", path)
-			return fs.SkipDir
+		// globs are matched against the path relative to the walked root
+		if relPath != "." && filter.IsExcluded(relPath, d.IsDir()) {
+			log.Debug().Msgf("'%s' is excluded", path)
+			files <- FileEntry{Path: path, Skip: SkipFiltered}
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
 		}
-		if !d.IsDir() {
+
+		if d.IsDir() {
+			ignores = ignores.enter(absPath)
+		} else if !filter.IsIncluded(relPath) {
+			log.Debug().Msgf("'%s' is not included", path)
+			files <- FileEntry{Path: path, Skip: SkipFiltered}
+		} else {
 			log.Debug().Msgf("file '%s' will be parsed", path)
-			files = append(files, path)
+			files <- FileEntry{Path: path}
 		}
 		return nil
 	})
 
-	return files, err
+	return err
 }
 
 
@@ -141,7 +257,7 @@ func parseDirGitignore_old(root string, config Config) (FileStatsMap, error) {
 
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, 8) // Or any concurrency limit
-	results := make(chan FileStatsMap)
+	results := make(chan FileReport)
 	counter := FileStatsMap{}
 
 	// Walk directory
@@ -170,8 +286,9 @@ func parseDirGitignore_old(root string, config Config) (FileStatsMap, error) {
 		go func(p string) {
 			defer wg.Done()
 			defer func() { <-sem }()
-			result := parseFile(p, config)
-			results <- result
+			if result := parseFile(FileEntry{Path: p}, config); result.Stats.Files > 0 {
+				results <- result
+			}
 		}(path)
 
 		return nil
@@ -184,7 +301,7 @@ func parseDirGitignore_old(root string, config Config) (FileStatsMap, error) {
 	}()
 
 	for r := range results {
-		counter.Merge(r)
+		counter.Merge(FileStatsMap{r.Language: r.Stats})
 	}
 
 	return counter, nil
@@ -0,0 +1,207 @@
+// Copyright 2025 Matteo Redaelli
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// stringList is a repeatable command line flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Filter selects the files and languages to be counted
+type Filter struct {
+	Include      []*regexp.Regexp
+	Exclude      []*regexp.Regexp
+	OnlyLangs    map[string]bool // by config key
+	ExcludeLangs map[string]bool
+}
+
+// NewFilter builds the filter of the command line options. The language
+// names are resolved with names, the config languages by lowercase name
+func NewFilter(include, exclude, includeRegex, excludeRegex, onlyLangs, excludeLangs []string, names map[string]string) (*Filter, error) {
+	var err error
+	filter := &Filter{}
+	if filter.OnlyLangs, err = langSet(onlyLangs, names); err != nil {
+		return nil, fmt.Errorf("invalid -only-lang: %w", err)
+	}
+	if filter.ExcludeLangs, err = langSet(excludeLangs, names); err != nil {
+		return nil, fmt.Errorf("invalid -exclude-lang: %w", err)
+	}
+	for _, glob := range include {
+		filter.Include = append(filter.Include, globToRegexp(glob))
+	}
+	for _, glob := range exclude {
+		filter.Exclude = append(filter.Exclude, globToRegexp(glob))
+	}
+	for _, expr := range includeRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include regex '%s': %w", expr, err)
+		}
+		filter.Include = append(filter.Include, re)
+	}
+	for _, expr := range excludeRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude regex '%s': %w", expr, err)
+		}
+		filter.Exclude = append(filter.Exclude, re)
+	}
+	return filter, nil
+}
+
+// langSet accepts repeated and comma separated language names, either
+// config keys (Cpp) or display names (C++), case insensitively
+func langSet(langs []string, names map[string]string) (map[string]bool, error) {
+	result := make(map[string]bool)
+	for _, value := range langs {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			lang, ok := names[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown language '%s'", name)
+			}
+			result[lang] = true
+		}
+	}
+	return result, nil
+}
+
+// globToRegexp converts a glob where "**" matches any number of
+// directories. Globs without a "/" are matched against the base name
+func globToRegexp(glob string) *regexp.Regexp {
+	var expr strings.Builder
+	if !strings.Contains(glob, "/") {
+		expr.WriteString("(^|/)")
+	} else {
+		expr.WriteString("^")
+		glob = strings.TrimPrefix(glob, "/")
+	}
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expr.WriteString("[^/]*")
+		case glob[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+func matchesAny(patterns []*regexp.Regexp, name string) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExcluded checks the exclude patterns against a file or directory path
+func (f *Filter) IsExcluded(name string, isDir bool) bool {
+	if f == nil || len(f.Exclude) == 0 {
+		return false
+	}
+	name = path.Clean(filepath.ToSlash(name))
+	if isDir {
+		// "dir/**" patterns also match the directory itself
+		return matchesAny(f.Exclude, name) || matchesAny(f.Exclude, name+"/")
+	}
+	return matchesAny(f.Exclude, name)
+}
+
+// IsIncluded checks the include patterns against a file path: with no
+// include patterns all files are included
+func (f *Filter) IsIncluded(name string) bool {
+	if f == nil || len(f.Include) == 0 {
+		return true
+	}
+	return matchesAny(f.Include, path.Clean(filepath.ToSlash(name)))
+}
+
+// argSuffixes returns a command line path and its suffixes starting at
+// each directory ("a/b/c.go", "b/c.go", "c.go"), as the root the globs are
+// relative to is not known
+func argSuffixes(name string) []string {
+	name = strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+	suffixes := []string{name}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' {
+			suffixes = append(suffixes, name[i+1:])
+		}
+	}
+	return suffixes
+}
+
+// IsExcludedArg checks the exclude patterns against a file or directory
+// given on the command line, from any of its directories
+func (f *Filter) IsExcludedArg(name string, isDir bool) bool {
+	for _, suffix := range argSuffixes(name) {
+		if f.IsExcluded(suffix, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIncludedArg checks the include patterns against a file given on the
+// command line, from any of its directories
+func (f *Filter) IsIncludedArg(name string) bool {
+	if f == nil || len(f.Include) == 0 {
+		return true
+	}
+	for _, suffix := range argSuffixes(name) {
+		if f.IsIncluded(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptsLanguage applies the --only-lang and --exclude-lang filters
+func (f *Filter) AcceptsLanguage(lang string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.OnlyLangs) > 0 && !f.OnlyLangs[lang] {
+		return false
+	}
+	return !f.ExcludeLangs[lang]
+}
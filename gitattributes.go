@@ -0,0 +1,161 @@
+// Copyright 2025 Matteo Redaelli
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sabhiram/go-gitignore"
+)
+
+const languageAttribute = "linguist-language"
+
+type attributePattern struct {
+	matcher  *ignore.GitIgnore
+	language string // "" when the attribute is unset
+}
+
+// attributesFile holds the linguist-language lines of a .gitattributes
+// file. Patterns are matched against paths relative to the base directory
+type attributesFile struct {
+	base     string
+	patterns []attributePattern
+}
+
+// attributesEntry loads an attributes file once, file is nil if missing
+type attributesEntry struct {
+	once sync.Once
+	file *attributesFile
+}
+
+// GitAttributes looks up the linguist-language attribute of the files,
+// loading the .gitattributes files once per directory. It is shared by
+// the parser workers, that wait only for the file they need
+type GitAttributes struct {
+	files sync.Map // *attributesEntry by directory
+	tops  sync.Map // git top level by directory
+}
+
+func NewGitAttributes() *GitAttributes {
+	return &GitAttributes{}
+}
+
+func compileAttributesFile(path string, base string) (*attributesFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("attributes file '%s' loaded", path)
+
+	af := &attributesFile{base: base}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			var language string
+			switch {
+			case strings.HasPrefix(attr, languageAttribute+"="):
+				language = attr[len(languageAttribute)+1:]
+			case attr == "-"+languageAttribute, attr == "!"+languageAttribute:
+				// unset or unspecified: overrides the previous lines
+			default:
+				continue
+			}
+			af.patterns = append(af.patterns, attributePattern{ignore.CompileIgnoreLines(fields[0]), language})
+		}
+	}
+	return af, nil
+}
+
+// match returns the language of the last line matching the path
+func (af *attributesFile) match(path string) (string, bool) {
+	relPath, err := filepath.Rel(af.base, path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	relPath = filepath.ToSlash(relPath)
+	for i := len(af.patterns) - 1; i >= 0; i-- {
+		if af.patterns[i].matcher.MatchesPath(relPath) {
+			return af.patterns[i].language, true
+		}
+	}
+	return "", false
+}
+
+// load returns the attributes file at path, caching it by key
+func (ga *GitAttributes) load(key string, path string, base string) *attributesFile {
+	value, _ := ga.files.LoadOrStore(key, &attributesEntry{})
+	entry := value.(*attributesEntry)
+	entry.once.Do(func() {
+		if af, err := compileAttributesFile(path, base); err == nil {
+			entry.file = af
+		}
+	})
+	return entry.file
+}
+
+// topLevel returns the cached git top level of dir. Concurrent lookups of
+// the same dir may both walk up, with the same result
+func (ga *GitAttributes) topLevel(dir string) string {
+	if top, ok := ga.tops.Load(dir); ok {
+		return top.(string)
+	}
+	top := findGitTopLevel(dir)
+	ga.tops.Store(dir, top)
+	return top
+}
+
+// language returns the linguist-language attribute of the file and
+// whether it is set or unset by any line. As in git, .git/info/attributes
+// comes first, then the .gitattributes files from the directory of the
+// file up to the top of the repository
+func (ga *GitAttributes) language(path string) (string, bool) {
+	if ga == nil {
+		return "", false
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+
+	dir := filepath.Dir(path)
+	top := ga.topLevel(dir)
+	if top == "" {
+		return "", false
+	}
+	info := filepath.Join(top, ".git", "info", "attributes")
+	if af := ga.load(info, info, top); af != nil {
+		if language, ok := af.match(path); ok {
+			return language, true
+		}
+	}
+	for ; strings.HasPrefix(dir, top); dir = filepath.Dir(dir) {
+		if af := ga.load(dir, filepath.Join(dir, ".gitattributes"), dir); af != nil {
+			if language, ok := af.match(path); ok {
+				return language, true
+			}
+		}
+		if dir == top {
+			break
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,199 @@
+// Copyright 2025 Matteo Redaelli
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sabhiram/go-gitignore"
+)
+
+type ignorePattern struct {
+	matcher *ignore.GitIgnore
+	negate  bool
+	dirOnly bool // "dir/" patterns match directories only
+}
+
+// ignoreFile holds the patterns of a .gitignore like file. Patterns are
+// matched against paths relative to the base directory
+type ignoreFile struct {
+	base     string
+	patterns []ignorePattern
+}
+
+// ignoreStack holds the ignore files in order of precedence: the global
+// core.excludesFile first, then .git/info/exclude and the .gitignore files
+// from the top of the repository down to the current directory
+type ignoreStack []*ignoreFile
+
+func compileIgnoreFile(path string, base string) (*ignoreFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("ignore file '%s' loaded", path)
+
+	ig := &ignoreFile{base: base}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.Trim(strings.TrimRight(line, "\r"), " ")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// negation is handled here, the matcher of a single pattern
+		// cannot tell a negated match from no match at all
+		negate := strings.HasPrefix(line, "!")
+		if negate {
+			line = line[1:]
+		}
+		ig.patterns = append(ig.patterns, ignorePattern{ignore.CompileIgnoreLines(line), negate, strings.HasSuffix(line, "/")})
+	}
+	return ig, nil
+}
+
+// match returns true if a pattern matches the path and, in that case,
+// whether the path is ignored. As in git the last matching pattern wins
+func (ig *ignoreFile) match(path string, isDir bool) (bool, bool) {
+	relPath, err := filepath.Rel(ig.base, path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return false, false
+	}
+	relPath = filepath.ToSlash(relPath)
+	for i := len(ig.patterns) - 1; i >= 0; i-- {
+		p := ig.patterns[i]
+		// only "dir/" patterns see the trailing slash of a directory, so
+		// that "build/*" does not match the build directory itself
+		target := relPath
+		if isDir && p.dirOnly {
+			target += "/"
+		}
+		if p.matcher.MatchesPath(target) {
+			return true, !p.negate
+		}
+	}
+	return false, false
+}
+
+// newIgnoreStack loads the ignore files applying to root (an absolute
+// path) that live outside of it: core.excludesFile, .git/info/exclude and
+// the .gitignore files of the parent directories inside the repository
+func newIgnoreStack(root string) ignoreStack {
+	var stack ignoreStack
+
+	top := findGitTopLevel(root)
+	base := top
+	if base == "" {
+		base = root
+	}
+
+	stack = stack.push(globalExcludesFile(), base)
+	if top == "" {
+		return stack
+	}
+	stack = stack.push(filepath.Join(top, ".git", "info", "exclude"), top)
+
+	var parents []string
+	for dir := filepath.Dir(root); strings.HasPrefix(dir, top) && dir != root; dir = filepath.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+		if dir == top {
+			break
+		}
+	}
+	for _, dir := range parents {
+		stack = stack.push(filepath.Join(dir, ".gitignore"), dir)
+	}
+	return stack
+}
+
+// push adds the ignore file at path, if it exists
+func (s ignoreStack) push(path string, base string) ignoreStack {
+	if path == "" {
+		return s
+	}
+	ig, err := compileIgnoreFile(path, base)
+	if err != nil {
+		return s
+	}
+	return append(s, ig)
+}
+
+// enter pushes the .gitignore file of the directory dir
+func (s ignoreStack) enter(dir string) ignoreStack {
+	return s.push(filepath.Join(dir, ".gitignore"), dir)
+}
+
+// leave pops the ignore files of the directories not containing path,
+// keeping the first fixed ones
+func (s ignoreStack) leave(path string, fixed int) ignoreStack {
+	for len(s) > fixed {
+		base := s[len(s)-1].base
+		if path == base || strings.HasPrefix(path, base+string(filepath.Separator)) {
+			break
+		}
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// isIgnored checks the ignore files starting from the deepest one, which
+// has the highest precedence
+func (s ignoreStack) isIgnored(path string, isDir bool) bool {
+	for i := len(s) - 1; i >= 0; i-- {
+		if matched, ignored := s[i].match(path, isDir); matched {
+			return ignored
+		}
+	}
+	return false
+}
+
+// findGitTopLevel returns the first directory containing .git walking up
+// from dir, or "" if dir is not inside a git repository
+func findGitTopLevel(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// globalExcludesFile returns the core.excludesFile of the git config or
+// its default $XDG_CONFIG_HOME/git/ignore
+func globalExcludesFile() string {
+	home, _ := os.UserHomeDir()
+	out, err := exec.Command("git", "config", "--get", "core.excludesFile").Output()
+	if err == nil {
+		path := strings.TrimSpace(string(out))
+		if strings.HasPrefix(path, "~/") && home != "" {
+			path = filepath.Join(home, path[2:])
+		}
+		return path
+	}
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		if home == "" {
+			return ""
+		}
+		config = filepath.Join(home, ".config")
+	}
+	return filepath.Join(config, "git", "ignore")
+}
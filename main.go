@@ -18,6 +18,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
 	"strings"
 	
 	"github.com/rs/zerolog"
@@ -25,11 +26,6 @@ import (
 )
 
 func main() {
-	config, err := LoadEmbeddedConfig()
-	if err != nil {
-		panic(fmt.Errorf("failed to parse embedded config: %w", err))
-	}
-
 	// Set output to human-friendly format (optional, for console debugging)
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
@@ -62,27 +58,76 @@ func main() {
 	// Set global log level
 	zerolog.SetGlobalLevel(level)
 
+	// goloc config check [options]
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "check" {
+		os.Exit(configCheck(os.Args[3:], os.Stdout))
+	}
+
 	// Define a flag: -o csv
 	countFiles := flag.Bool("f", false, "count files without parsing lines")
 	outputFormat := flag.String("o", "table", "output format (table|csv|json)")
 	showLanguages := flag.Bool("l", false, "show supported languages/extensions and exit")
+	jobs := runtime.GOMAXPROCS(0)
+	flag.IntVar(&jobs, "j", jobs, "number of files parsed in parallel")
+	flag.IntVar(&jobs, "jobs", jobs, "number of files parsed in parallel (same as -j)")
+	maxLineLength := flag.Int("max-line-length", 0, "skip files with lines longer than n bytes (0 = no limit)")
+	configFile := flag.String("config", "", "languages config file merged over the embedded one")
 	unknownFiles := flag.Bool("u", false, "count and show files with unknown extention")
+	perFile := flag.Bool("p", false, "show stats for each file instead of each language")
+	sortBy := flag.String("s", "code", "sort per file stats by column (path|lang|skipped|lines|code|comments|docs|blanks|mixed)")
+	top := flag.Int("n", 0, "show only the first n files of the per file stats (0 = all)")
+	skipReport := flag.Bool("r", false, "show the skipped files grouped by reason")
+	sniffSize := flag.Int("sniff-size", 8000, "bytes read to detect binary files (0 = no detection)")
+	ignoreCase := flag.Bool("ignore-case", false, "match file names (Makefile, Dockerfile.*) case insensitively")
+	noModelines := flag.Bool("no-modelines", false, "ignore the vim and emacs modelines (vim: ft=python) when detecting languages")
+	latin1 := flag.Bool("latin1", false, "decode files that are not valid UTF-8 as Latin-1")
+	blankLanguages := flag.Bool("b", false, "count all the lines of the languages marked blank in the config (e.g. JSON) as blanks")
+	showMixed := flag.Bool("m", false, "show the lines with both code and comments (Mixed column)")
+	mixedLines := flag.String("mixed", MixedCode, "count the lines with both code and comments as code or as both code and comment (code|both)")
+	maxFileSize := flag.Int64("max-file-size", 0, "skip files larger than n bytes (0 = no limit)")
+	var include, exclude, includeRegex, excludeRegex, onlyLangs, excludeLangs stringList
+	flag.Var(&include, "include", "count only the files matching the glob (repeatable, ** matches any dir)")
+	flag.Var(&exclude, "exclude", "skip the files and dirs matching the glob (repeatable, ** matches any dir)")
+	flag.Var(&includeRegex, "include-regex", "count only the files matching the regex (repeatable)")
+	flag.Var(&excludeRegex, "exclude-regex", "skip the files and dirs matching the regex (repeatable)")
+	flag.Var(&onlyLangs, "only-lang", "count only the languages (repeatable or comma separated)")
+	flag.Var(&excludeLangs, "exclude-lang", "skip the languages (repeatable or comma separated)")
 	
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] [file or dir] ...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s config check [-config file]\n", os.Args[0])
 		fmt.Fprintln(os.Stderr, "\nOptions:")
 		flag.PrintDefaults()
 	}
 	// Parse the flags
 	flag.Parse()
 
+	config, err := LoadConfig(UserConfigFiles(*configFile))
+	if err != nil {
+		log.Error().Msgf("failed to load config: %v", err)
+		os.Exit(1)
+	}
+
 	if *showLanguages {
 		for ext, lang := range (*config).Extensions {
 			fmt.Fprintln(os.Stdout, ext, "\t", lang)
 		}
 		os.Exit(0)
 	}
-	(*config).Options = Options{UnknownFiles: *unknownFiles, CountFiles: *countFiles}
+	if jobs < 1 {
+		log.Error().Msgf("Invalid number of jobs (-j) %d, it must be at least 1", jobs)
+		os.Exit(1)
+	}
+	if *mixedLines != MixedCode && *mixedLines != MixedBoth {
+		log.Error().Msgf("Unknown mixed lines attribution (-mixed) '%s'", *mixedLines)
+		os.Exit(1)
+	}
+	filter, err := NewFilter(include, exclude, includeRegex, excludeRegex, onlyLangs, excludeLangs, (*config).Names)
+	if err != nil {
+		log.Error().Msgf("%v", err)
+		os.Exit(1)
+	}
+	(*config).Options = Options{UnknownFiles: *unknownFiles, CountFiles: *countFiles, PerFile: *perFile, Filter: filter, MaxLineLength: *maxLineLength, MaxFileSize: *maxFileSize, SkipReport: *skipReport, SniffSize: *sniffSize, Latin1: *latin1, IgnoreCase: *ignoreCase, MixedLines: *mixedLines, ShowMixed: *showMixed, BlankLanguages: *blankLanguages, NoModelines: *noModelines, Attributes: NewGitAttributes()}
 	
 	// Remaining args after flags (e.g. file1, file2)
 	input_files := flag.Args()
@@ -92,27 +137,48 @@ func main() {
 		input_files = []string{"."}
 	}
 	
-	files := listFiles(input_files)
-	
-	if len(files) == 0 {
+	// walker -> workers -> aggregator: files are parsed while the
+	// directories are still being walked
+	files := make(chan FileEntry, jobs)
+	go listFiles(input_files, filter, files)
+
+	result := parseFiles(files, *config, jobs)
+
+	if result.Found == 0 {
 		log.Warn().Msgf("No files found in '%v'", input_files)
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	counter := FileStatsMap{}
+	summary := BuildSummaryStats(result.Stats)
+	summary.SkippedFiles = result.Skipped
+	summary.ShowMixed = *showMixed
 
-	counter = parseFiles(files, *config)
-
-	summary := BuildSummaryStats(counter)
+	if *perFile {
+		summary.Files, err = SortFileReports(result.Files, *sortBy, *top)
+		if err != nil {
+			log.Error().Msgf("%v", err)
+			os.Exit(1)
+		}
+		if summary.Files == nil {
+			summary.Files = []FileReport{}
+		}
+	}
 	
 	switch *outputFormat {
 	case "csv":
 		PrintSummaryStatsCsv(summary)
+		if *skipReport {
+			fmt.Println()
+			PrintSkippedFilesCsv(summary)
+		}
 	case "json":
 		PrintSummaryStatsJson(summary)
 	case "table":
 		PrintSummaryStatsTable(summary)
+		if *skipReport {
+			PrintSkippedFilesTable(summary)
+		}
 	default:
 		log.Error().Msgf("Unknown output format (-o) '%s'", *outputFormat)
 	}
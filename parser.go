@@ -16,9 +16,15 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/rs/zerolog/log"
 )
@@ -28,98 +34,441 @@ const (
     None BlockType = iota
     Comment
     String
+    Quote
+    Verbatim
 )
 
 type Block struct {
 	blockType BlockType
+	start_string string
 	end_string string
+	// nesting level of a multiline comment
+	depth int
+	// end delimiter of a code region of a literate file
+	literate_end string
+	// the comment or string is documentation
+	doc bool
 }
 
-func is_line_with_single_comment(line string, config LanguageConfig) bool {
-	// line is already trimmed
-	for _, s := range config.SingleComments {
-		if strings.HasPrefix(line, s) {
+// LineContent tells what has been found scanning a line
+type LineContent struct {
+	code    bool
+	comment bool
+	doc     bool
+}
+
+func (c LineContent) merge(other LineContent) LineContent {
+	return LineContent{
+		code:    c.code || other.code,
+		comment: c.comment || other.comment,
+		doc:     c.doc || other.doc,
+	}
+}
+
+type TokenType int
+const (
+	NoToken TokenType = iota
+	LineCommentToken
+	CommentToken
+	DocLineCommentToken
+	DocCommentToken
+	StringToken
+	QuoteToken
+	VerbatimToken
+)
+
+// has_important_syntax checks if the line contains a token that needs the
+// full scan of the line
+func has_important_syntax(line string, config LanguageConfig) bool {
+	for _, token := range config.important {
+		if strings.Contains(line, token) {
 			return true
 		}
 	}
-	return false	
+	return false
+}
+
+// is_doc_start checks if a documentation comment starts at the beginning
+// of line. A doc marker followed by its last char ("////", "/***") or
+// closed at once ("/**/") is an ordinary comment
+func is_doc_start(line string, start_string string, end_string string) bool {
+	if !strings.HasPrefix(line, start_string) {
+		return false
+	}
+	rest := line[len(start_string):]
+	if strings.HasPrefix(rest, start_string[len(start_string)-1:]) {
+		return false
+	}
+	return end_string == "" || !strings.HasPrefix(line[len(start_string)-1:], end_string)
 }
 
 
-func find_end_block(line string, end_string string, index int) int {
-	index_new := index + len(end_string)
-	if len(line) > index_new {
-		return strings.Index(line[index_new:], end_string)
-	} else {
-		return -1
+// find_quote_end returns the index just after the end_string closing a
+// quoted string, skipping backslash escaped chars, or -1 if the string
+// continues on the next line
+func find_quote_end(line string, end_string string, index int) int {
+	for i := index; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(line[i:], end_string) {
+			return i + len(end_string)
+		}
 	}
+	return -1
 }
 
-func find_start_block_comment(line string, config LanguageConfig, block *Block) error {
-	idx_start := -1
-	comment_idx := -1
-	comment_end_block := ""
-	string_idx := -1
-	string_end_block := ""
-	for _, b := range config.MultilineComments {
-		i := strings.Index(line, b[0])
-		if i >= 0 && (comment_idx == -1 || i < comment_idx) {
-			comment_idx       = i
-			comment_end_block = b[1]	
+// char_literal_len returns the length of a one char literal ('"', '`',
+// '\'') starting the line, or 0. Its quote must not start a string nor a
+// raw string
+func char_literal_len(line string) int {
+	switch {
+	case len(line) >= 3 && line[0] == '\'' && line[1] != '\\' && line[1] != '\'' && line[2] == '\'':
+		return 3
+	case len(line) >= 4 && line[0] == '\'' && line[1] == '\\' && line[3] == '\'':
+		return 4
+	}
+	return 0
+}
+
+// is_continued checks if a quoted string closed by end_string continues
+// on the next line: backtick strings (javascript template literals, shell
+// command substitutions) span lines, the others only after a backslash
+func is_continued(line string, end_string string) bool {
+	return end_string == "`" || strings.HasSuffix(line, "\\")
+}
+
+// find_comment_end returns the index just after the end_string closing
+// the current multiline comment, or -1 if the comment continues on the next
+// line. For nested comments the block depth is updated while scanning
+func find_comment_end(line string, block *Block, index int, config LanguageConfig) int {
+	nested := is_nested_comment(block.start_string, config)
+	for i := index; i < len(line); {
+		switch {
+		case strings.HasPrefix(line[i:], block.end_string):
+			block.depth--
+			i += len(block.end_string)
+			if block.depth == 0 || !nested {
+				return i
+			}
+		case nested && strings.HasPrefix(line[i:], block.start_string):
+			block.depth++
+			i += len(block.start_string)
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// comment_start returns the start string of the ordinary comment closed
+// by the same end_string of a doc comment ("/*" for "/**"), used to track
+// the nesting of doc comments
+func comment_start(start_string string, end_string string, config LanguageConfig) string {
+	for _, pairs := range [][][]string{config.NestedComments, config.MultilineComments} {
+		for _, b := range pairs {
+			if b[1] == end_string && strings.HasPrefix(start_string, b[0]) {
+				return b[0]
+			}
+		}
+	}
+	return start_string
+}
+
+func is_nested_comment(start_string string, config LanguageConfig) bool {
+	if config.Nested {
+		return true
+	}
+	for _, b := range config.NestedComments {
+		if b[0] == start_string {
+			return true
+		}
+	}
+	return false
+}
+
+// match_token checks if a comment, doc string or quote starts at the
+// beginning of line. The longest start marker wins, so that for instance
+// python """ is not taken for an empty "" string
+func match_token(line string, config LanguageConfig) (TokenType, string, string) {
+	token := NoToken
+	start_string := ""
+	end_string := ""
+	check := func(t TokenType, start string, end string) {
+		if start == "" || len(start) <= len(start_string) || !strings.HasPrefix(line, start) {
+			return
+		}
+		if t == DocLineCommentToken && !is_doc_start(line, start, "") ||
+			t == DocCommentToken && !is_doc_start(line, start, end) {
+			return
 		}
+		token = t
+		start_string = start
+		end_string = end
+	}
+	for _, b := range config.MultilineComments {
+		check(CommentToken, b[0], b[1])
+	}
+	for _, b := range config.NestedComments {
+		check(CommentToken, b[0], b[1])
+	}
+	for _, s := range config.SingleComments {
+		check(LineCommentToken, s, "")
+	}
+	for _, b := range config.DocMultilineComments {
+		check(DocCommentToken, b[0], b[1])
+	}
+	for _, s := range config.DocComments {
+		check(DocLineCommentToken, s, "")
 	}
 	for _, b := range config.MultilineStrings {
-		i := strings.Index(line, b[0])
-		log.Debug().Msgf("multiline string start=%v, u=%v", b[0], i)
-		if i >= 0 && (string_idx == -1 || i < string_idx) {
-			string_idx       = i
-			string_end_block = b[1]	
+		check(StringToken, b[0], b[1])
+	}
+	for _, b := range config.Quotes {
+		check(QuoteToken, b[0], b[1])
+	}
+	for _, b := range config.VerbatimQuotes {
+		start, end := raw_string_delimiters(line, b[0], b[1])
+		check(VerbatimToken, start, end)
+	}
+	return token, start_string, end_string
+}
+
+// raw_string_delimiters returns the delimiters of a raw string with any
+// number of hashes: the pair r#" "# also matches r###" "###, as in rust
+func raw_string_delimiters(line string, start_string string, end_string string) (string, string) {
+	hash := strings.IndexByte(start_string, '#')
+	if hash < 0 {
+		return start_string, end_string
+	}
+	prefix := start_string[:hash]
+	quote := strings.TrimLeft(start_string[hash:], "#")
+	hashes := start_string[hash:len(start_string)-len(quote)]
+	if quote == "" || end_string != quote+hashes || !strings.HasPrefix(line, prefix) {
+		return start_string, end_string
+	}
+	n := len(line[len(prefix):]) - len(strings.TrimLeft(line[len(prefix):], "#"))
+	if n == 0 || !strings.HasPrefix(line[len(prefix)+n:], quote) {
+		return start_string, end_string
+	}
+	hashes = strings.Repeat("#", n)
+	return prefix + hashes + quote, quote + hashes
+}
+
+// scan_line walks the line from index to the end, skipping the content of
+// quoted strings, and updates block when a multiline comment, doc string
+// or quoted string is left open at the end of the line.
+// It returns the code, comments and docs found in the line
+func scan_line(line string, index int, config LanguageConfig, block *Block) LineContent {
+	var content LineContent
+	i := index
+
+	if block.blockType == Quote {
+		content.code = true
+		idx_end := find_quote_end(line, block.end_string, i)
+		if idx_end < 0 {
+			if !is_continued(line, block.end_string) {
+				// an unterminated string ends with the line
+				block.blockType = None
+				block.end_string = ""
+			}
+			return content
 		}
+		log.Debug().Msg("Multirows quoted string end found")
+		block.blockType = None
+		block.end_string = ""
+		i = idx_end
 	}
-	log.Printf("comment_idx=%v, string_idx=%v", comment_idx, string_idx)
-	
-	switch {
-	case comment_idx >= 0 && (string_idx == -1 || comment_idx < string_idx):
-		idx_start = comment_idx
-		log.Debug().Msg("Multiline 'comment' startfound")
-		block.blockType =  Comment
-		block.end_string = comment_end_block
-	case string_idx >= 0 && (comment_idx == -1 || string_idx < comment_idx):
-		idx_start = string_idx
-		log.Debug().Msg("Multiline 'string' start found")
-		block.blockType = String
-		block.end_string = string_end_block
-	case comment_idx == string_idx:
-	default:
-		log.Debug().Msg("No multiline string/comment start found")
-		block.blockType =  None
+	if block.blockType == Verbatim {
+		content.code = true
+		idx_end := strings.Index(line[i:], block.end_string)
+		if idx_end < 0 {
+			return content
+		}
+		log.Debug().Msg("Multirows verbatim string end found")
+		i += idx_end + len(block.end_string)
+		block.blockType = None
 		block.end_string = ""
-		return nil
-	}
-	log.Debug().Msgf("find_start_block_comment - block: %v", block)
-	// TODO if multiline start and end patterns  are in the same row
-	// checking if multine comments/docs start and end in teh same line
-	if idx_start >= 0 && string_end_block != "" {
-		idx_end := find_end_block(line, string_end_block, idx_start)
-		if idx_end > -1 {
-			log.Debug().Msg("Multirows comment/doc starts and ends in the same line")
+	}
+
+	for i < len(line) {
+		token, start_string, end_string := match_token(line[i:], config)
+		if token != LineCommentToken && token != DocLineCommentToken {
+			if n := char_literal_len(line[i:]); n > 0 {
+				content.code = true
+				i += n
+				continue
+			}
+		}
+		switch token {
+		case LineCommentToken:
+			content.comment = true
+			return content
+		case DocLineCommentToken:
+			content.doc = true
+			return content
+		case CommentToken, DocCommentToken:
+			doc := token == DocCommentToken
+			if doc {
+				content.doc = true
+			} else {
+				content.comment = true
+			}
+			block.start_string = start_string
+			if doc {
+				block.start_string = comment_start(start_string, end_string, config)
+			}
+			block.end_string = end_string
+			block.depth = 1
+			idx_end := find_comment_end(line, block, i+len(start_string), config)
+			if idx_end < 0 {
+				log.Debug().Msg("Multiline 'comment' start found")
+				block.blockType = Comment
+				block.doc = doc
+				return content
+			}
+			block.start_string = ""
 			block.end_string = ""
-		//		if idx_start == 0 and idx_end == (len(string) - len(string_end_block)) {
+			block.depth = 0
+			i = idx_end
+		case StringToken:
+			// a doc string starting the line is documentation
+			doc := i == 0
+			if doc {
+				content.doc = true
+			} else {
+				content.code = true
+			}
+			idx_end := strings.Index(line[i+len(start_string):], end_string)
+			if idx_end < 0 {
+				log.Debug().Msg("Multiline 'string' start found")
+				block.blockType = String
+				block.end_string = end_string
+				block.doc = doc
+				return content
+			}
+			i += len(start_string) + idx_end + len(end_string)
+		case QuoteToken:
+			content.code = true
+			idx_end := find_quote_end(line, end_string, i+len(start_string))
+			if idx_end < 0 {
+				if is_continued(line, end_string) {
+					log.Debug().Msg("Multiline 'quote' start found")
+					block.blockType = Quote
+					block.end_string = end_string
+				}
+				// else the stray quote ends with the line
+				return content
+			}
+			i = idx_end
+		case VerbatimToken:
+			// no escapes nor comments inside raw strings
+			content.code = true
+			idx_end := strings.Index(line[i+len(start_string):], end_string)
+			if idx_end < 0 {
+				log.Debug().Msg("Multiline 'verbatim' start found")
+				block.blockType = Verbatim
+				block.end_string = end_string
+				return content
+			}
+			i += len(start_string) + idx_end + len(end_string)
+		default:
+			if line[i] != ' ' && line[i] != '\t' {
+				content.code = true
+			}
+			i++
 		}
 	}
-	
-	//block.end_string = ""
-	log.Debug().Msgf("find_start_block_comment - block: %v", block)
-	return nil
+	return content
 }
 
-func parseLine(line string, language string, config LanguageConfig, block *Block, stats *FileStats) {
+// count_line counts a scanned line. A line with code and comments is
+// mixed: it is counted as code and, with the "both" MixedLines option, as
+// comment too
+func count_line(content LineContent, options Options, stats *FileStats) {
+	switch {
+	case content.code && (content.comment || content.doc):
+		stats.Mixed++
+		stats.Code++
+		if options.MixedLines != MixedBoth {
+			return
+		}
+		if content.doc {
+			stats.Docs++
+		} else {
+			stats.Comments++
+		}
+	case content.code:
+		stats.Code++
+	case content.doc:
+		stats.Docs++
+	default:
+		stats.Comments++
+	}
+}
+
+// parse_literate_line counts the prose of literate files as comments. It
+// returns the code of the line, without the bird track, or false if the
+// line has already been counted
+func parse_literate_line(line string, config LanguageConfig, block *Block, stats *FileStats) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if block.literate_end != "" {
+		// inside a code region
+		if strings.HasPrefix(trimmed, block.literate_end) {
+			log.Debug().Msg("Literate code region end found")
+			block.literate_end = ""
+			stats.Comments++
+			return "", false
+		}
+		return line, true
+	}
+	for _, prefix := range config.LiteratePrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return line[len(prefix):], true
+		}
+	}
+	for _, b := range config.LiterateCode {
+		if strings.HasPrefix(trimmed, b[0]) {
+			log.Debug().Msg("Literate code region start found")
+			block.literate_end = b[1]
+			stats.Comments++
+			return "", false
+		}
+	}
+	if trimmed == "" {
+		stats.Blanks++
+	} else {
+		stats.Comments++
+	}
+	return "", false
+}
+
+func parseLine(line string, language string, config LanguageConfig, options Options, block *Block, stats *FileStats) {
+	if config.Blank && options.BlankLanguages {
+		// data formats without code, as tokei does
+		stats.Blanks++
+		return
+	}
+	if config.Literate {
+		code, ok := parse_literate_line(line, config, block, stats)
+		if !ok {
+			return
+		}
+		line = code
+	}
 	trimmed := strings.TrimSpace(line)
 	log.Debug().Msgf("parseLine - Block: %v", block)
 	switch block.blockType {
 	case None:
 		// not inside a multiline block
-		if is_line_with_single_comment(trimmed, config) {
+		// a longer block comment marker wins over the line comment one
+		// ("--[[" in lua, "###" in coffeescript)
+		switch token, _, _ := match_token(trimmed, config); token {
+		case DocLineCommentToken:
+			stats.Docs++
+			return
+		case LineCommentToken:
 			stats.Comments++
 			return
 		}
@@ -127,38 +476,45 @@ func parseLine(line string, language string, config LanguageConfig, block *Block
 			stats.Blanks++
 			return
 		}
-			
-		find_start_block_comment(trimmed, config, block)
-		log.Debug().Msgf("parseLine - Block: %v", block)
-		switch block.blockType {
-		case None: 
+		if !has_important_syntax(trimmed, config) {
+			// fast path: no comments nor strings in the line
 			stats.Code++
 			return
-		case Comment:
-			stats.Comments++
-			// starting a multiline block
-			// end block could be in the same line
-		case String:
-			stats.Code++				
-		}		
+		}
+		count_line(scan_line(trimmed, 0, config, block), options, stats)
+	case Quote, Verbatim:
+		// inside a multiline quoted or verbatim string
+		content := scan_line(trimmed, 0, config, block)
+		content.code = true
+		count_line(content, options, stats)
 	case Comment:
-		stats.Comments++
+		content := LineContent{comment: !block.doc, doc: block.doc}
 		
 		// inside a multiline block
-		if strings.Contains(trimmed, block.end_string) {
+		if idx_end := find_comment_end(trimmed, block, 0, config); idx_end >= 0 {
 			log.Debug().Msg("Multirows comment end found")
 			block.blockType = None
+			block.start_string = ""
 			block.end_string = ""
+			block.depth = 0
+			block.doc = false
+			// code or a new block after the end of the comment
+			content = content.merge(scan_line(trimmed, idx_end, config, block))
 		}
+		count_line(content, options, stats)
 	case String:
-		stats.Code++
+		content := LineContent{code: !block.doc, doc: block.doc}
 		
 		// inside a multiline block
-		if strings.Contains(trimmed, block.end_string) {
+		if idx_end := strings.Index(trimmed, block.end_string); idx_end >= 0 {
 			log.Debug().Msg("Multirows string end found")
+			idx_end += len(block.end_string)
 			block.blockType = None
 			block.end_string = ""
+			block.doc = false
+			content = content.merge(scan_line(trimmed, idx_end, config, block))
 		}
+		count_line(content, options, stats)
 	// default:
 	// 	//TODO Raise
 		
@@ -166,33 +522,119 @@ func parseLine(line string, language string, config LanguageConfig, block *Block
 	log.Debug().Msgf("parseLine - block: %v", block)
 }
 
-func parseFile(filename string, config Config) FileStatsMap {
+var errLineTooLong = errors.New("line too long")
+
+// readLine reads a line of any length, without the trailing "\n" or
+// "\r\n". With maxLength > 0 longer lines return errLineTooLong
+func readLine(reader *bufio.Reader, maxLength int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if maxLength > 0 && len(line) > maxLength {
+			return "", fmt.Errorf("%w (more than %d bytes)", errLineTooLong, maxLength)
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(line) > 0:
+			// last line without newline
+		case err != nil:
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+	}
+}
+
+var errFileTooLarge = errors.New("file too large")
+var errBinaryFile = errors.New("binary file")
+
+func parseFile(entry FileEntry, config Config) FileReport {
 	var language string
 	var languageConfig LanguageConfig
-	
+	filename := entry.Path
+
+	if entry.Skip != "" {
+		return FileReport{Path: filename, Reason: entry.Skip, Error: entry.Error}
+	}
+
 	log.Info().Msgf("Parse file '%s'", filename)
-	lang, err := findLanguage(filename, config)
 	if config.Options.CountFiles {
-		return FileStatsMap{lang: FileStats{Files: 1}}
+		lang, detector, _ := findLanguage(filename, config, nil, nil)
+		if !config.Options.Filter.AcceptsLanguage(lang) {
+			return FileReport{Path: filename, Reason: SkipFiltered, Error: "language " + lang}
+		}
+		return FileReport{Path: filename, Language: lang, Detector: detector, Stats: FileStats{Files: 1}}
+	}
+
+	// the beginning of the file is read once, to detect its language and
+	// whether it is binary
+	var reader *bufio.Reader
+	var data []byte
+	file, readErr := os.Open(filename)
+	if readErr == nil {
+		defer file.Close()
+		size := max(config.Options.SniffSize, heuristicsSize)
+		reader = bufio.NewReaderSize(file, size)
+		if data, readErr = reader.Peek(size); readErr == io.EOF {
+			readErr = nil
+		}
 	}
+	if readErr != nil {
+		// the language is still told by the file name
+		file = nil
+	}
+	lang, detector, err := findLanguage(filename, config, file, data[:min(len(data), heuristicsSize)])
 	if err != nil {
 		if ! config.Options.UnknownFiles {
-			return nil
+			return FileReport{Path: filename, Reason: SkipUnknownLanguage}
 		} else {
 			unknown := "unknown_" + lang
-			return FileStatsMap{unknown: FileStats{Files: 1, Skipped: 1}}
+			return FileReport{Path: filename, Language: unknown, Stats: FileStats{Files: 1, Skipped: 1}, Reason: SkipUnknownLanguage}
 		}
 	}
+	if !config.Options.Filter.AcceptsLanguage(lang) {
+		log.Debug().Msgf("file '%s' language '%s' is filtered out", filename, lang)
+		return FileReport{Path: filename, Reason: SkipFiltered, Error: "language " + lang}
+	}
 	language = lang
 	languageConfig = config.Languages[language]
 
-	log.Debug().Msgf("file '%s' is related to language '%s'", filename, language)
-	
-	file, err := os.Open(filename)
-	if err != nil {
-		return FileStatsMap{language: FileStats{Files: 1, Skipped: 1}}
+	log.Debug().Msgf("file '%s' is related to language '%s' (%s)", filename, language, detector)
+	if readErr != nil {
+		return skippedFile(filename, language, detector, readErr)
+	}
+
+	if config.Options.MaxFileSize > 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return skippedFile(filename, language, detector, err)
+		}
+		if info.Size() > config.Options.MaxFileSize {
+			return skippedFile(filename, language, detector, fmt.Errorf("%w (%d bytes)", errFileTooLarge, info.Size()))
+		}
+	}
+
+	// at least 4 bytes are sniffed to detect the byte order mark
+	data = data[:min(len(data), max(config.Options.SniffSize, 4))]
+	encoding, bom := detectEncoding(data, config.Options.Latin1)
+	log.Debug().Msgf("file '%s' encoding is %v", filename, encoding)
+	if config.Options.SniffSize > 0 {
+		switch encoding {
+		case UTF8:
+			if isBinary(data) {
+				return skippedFile(filename, language, detector, errBinaryFile)
+			}
+		case Latin1:
+			if bytes.IndexByte(data, 0) >= 0 {
+				return skippedFile(filename, language, detector, errBinaryFile)
+			}
+		}
+	}
+	reader.Discard(bom)
+	if encoding != UTF8 {
+		reader = newDecodingReader(reader, encoding)
 	}
-	defer file.Close()
 
 	block := Block{
 		blockType:  None,
@@ -201,54 +643,145 @@ func parseFile(filename string, config Config) FileStatsMap {
 	
 	var stats FileStats
 	stats.Files++
-	
-	scanner := bufio.NewScanner(file)
+	var embedded *EmbeddedBlock
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		stats.Lines++
+	for {
+		line, err := readLine(reader, config.Options.MaxLineLength)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return skippedFile(filename, language, detector, err)
+		}
 		log.Debug().Msgf("Line: '%s'", line)
+
+		closed := false
+		if embedded != nil {
+			if embedded.isEnd(line, languageConfig.Kind) {
+				// the closing tag or fence is counted for the host
+				log.Debug().Msgf("embedded '%s' block end found", embedded.language)
+				stats.AddChild(embedded.language, embedded.stats)
+				embedded = nil
+				closed = true
+			} else if embedded.language != "" {
+				embedded.stats.Lines++
+				parseLine(line, embedded.language, embedded.config, config.Options, &embedded.block, &embedded.stats)
+				continue
+			}
+		}
+
+		stats.Lines++
+		comments := stats.Comments
 		log.Debug().Msgf("parseFile - block: %v, stats: %v", block, stats)
-		parseLine(line, language, languageConfig, &block, &stats)
+		parseLine(line, language, languageConfig, config.Options, &block, &stats)
 		log.Debug().Msgf("parseFile - block: %v, stats: %v", block, stats)
+
+		// embedded blocks are not opened by comment lines, but they are by
+		// the lines opening a literate code region (markdown fences)
+		opener := stats.Comments == comments || block.literate_end != ""
+		if languageConfig.Kind != "" && embedded == nil && !closed && opener {
+			embedded = findEmbeddedStart(line, languageConfig.Kind, config)
+			if embedded != nil {
+				// a quote left open by the opening tag line is dropped
+				block = Block{literate_end: block.literate_end}
+			}
+		}
+	}
+	if embedded != nil {
+		stats.AddChild(embedded.language, embedded.stats)
 	}
 	
-	resp := FileStatsMap{}
-	resp[language] = stats
-	return resp
+	return FileReport{Path: filename, Language: language, Detector: detector, Stats: stats}
+}
+
+// skippedFile reports a file that could not be parsed: lines counted
+// before the error are discarded
+func skippedFile(filename string, language string, detector string, err error) FileReport {
+	log.Warn().Msgf("file '%s' skipped: %v", filename, err)
+
+	reason := SkipReadError
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		reason = SkipPermissionDenied
+	case errors.Is(err, errLineTooLong), errors.Is(err, errFileTooLarge):
+		reason = SkipTooLarge
+	case errors.Is(err, errBinaryFile):
+		reason = SkipBinary
+	}
+	return FileReport{
+		Path:     filename,
+		Language: language,
+		Detector: detector,
+		Stats:    FileStats{Files: 1, Skipped: 1},
+		Reason:   reason,
+		Error:    err.Error(),
+	}
 }
 
-func parseFiles(files []string, config Config) FileStatsMap {
+// ParseResult is built by the aggregator of parseFiles
+type ParseResult struct {
+	Stats   FileStatsMap
+	Files   []FileReport  // with the PerFile option
+	Skipped SkippedFilesMap // with the SkipReport option
+	Found   int           // files received from the walker
+}
+
+// parseFiles parses the files received from the channel with a pool of
+// jobs workers
+func parseFiles(files <-chan FileEntry, config Config, jobs int) ParseResult {
 	var wg sync.WaitGroup
-	results := make(chan FileStatsMap, len(files)) // buffered to avoid blocking
+	var received atomic.Int64
+	jobs = max(jobs, 1)
+	results := make(chan FileReport, jobs)
 
-	for _, file := range files {
+	for i := 0; i < jobs; i++ {
 		wg.Add(1)
-		go func(f string) {
+		go func() {
 			defer wg.Done()
-			stats := parseFile(f, config)
-			results <- stats
-		}(file)
+			for f := range files {
+				received.Add(1)
+				results <- parseFile(f, config)
+			}
+		}()
 	}
 
-	wg.Wait()
-	close(results)
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
 
 	// Collect and return counter
-	counter := FileStatsMap{}
-	for result := range results {
-		counter.Merge(result)
+	result := ParseResult{Stats: FileStatsMap{}}
+	if config.Options.SkipReport {
+		result.Skipped = SkippedFilesMap{}
+	}
+	for report := range results {
+		if report.Reason != "" && result.Skipped != nil {
+			result.Skipped.Add(report)
+		}
+		// files not counted at all (i.e. filtered out) have no stats
+		if report.Stats.Files == 0 {
+			continue
+		}
+		result.Stats.Merge(FileStatsMap{report.Language: report.Stats})
+		if config.Options.PerFile {
+			result.Files = append(result.Files, report)
+		}
 	}
-	return counter
+	result.Found = int(received.Load())
+	return result
 }
 
 
-func parseDir(root string, config Config) FileStatsMap {
-	files, err := listDirFiles(root)
-	
-	if err != nil {
-		panic(err)
-	}
+func parseDir(root string, config Config, jobs int) ParseResult {
+	jobs = max(jobs, 1)
+	files := make(chan FileEntry, jobs)
+	go func() {
+		defer close(files)
+		if err := listDirFiles(root, config.Options.Filter, files); err != nil {
+			log.Error().Msgf("%s: error: %v", root, err)
+		}
+	}()
 
-	return parseFiles(files, config)
+	return parseFiles(files, config, jobs)
 }
@@ -0,0 +1,106 @@
+// Copyright 2025 Matteo Redaelli
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// counts are the line counters of a file: lines, code, comments, docs,
+// blanks and mixed
+type counts [6]int
+
+func countsOf(stats FileStats) counts {
+	return counts{stats.Lines, stats.Code, stats.Comments, stats.Docs, stats.Blanks, stats.Mixed}
+}
+
+func testConfig(t *testing.T, mixedLines string) Config {
+	t.Helper()
+	config, err := LoadEmbeddedConfig()
+	if err != nil {
+		t.Fatalf("embedded config: %v", err)
+	}
+	config.Options = Options{SniffSize: 8000, MixedLines: mixedLines}
+	return *config
+}
+
+// TestParseFixtures parses the regression fixtures of testdata
+func TestParseFixtures(t *testing.T) {
+	tests := []struct {
+		file     string
+		language string
+		want     counts
+		children map[string]counts
+	}{
+		{"a.py", "Python", counts{7, 2, 1, 3, 1, 0}, nil},
+		{"comment_end.c", "C", counts{12, 4, 7, 1, 0, 4}, nil},
+		{"comments.coffee", "CoffeeScript", counts{5, 1, 4, 0, 0, 0}, nil},
+		{"comments.lua", "Lua", counts{5, 1, 4, 0, 0, 0}, nil},
+		{"docs.rs", "Rust", counts{11, 2, 2, 7, 0, 2}, nil},
+		{"embedded.vue", "Vue", counts{9, 7, 0, 0, 2, 0}, map[string]counts{
+			"Css":        {2, 1, 1, 0, 0, 0},
+			"TypeScript": {4, 3, 1, 0, 0, 0},
+		}},
+		{"empty.rs", "Rust", counts{0, 0, 0, 0, 0, 0}, nil},
+		{"fences.md", "Markdown", counts{13, 1, 8, 0, 4, 0}, map[string]counts{
+			"Go":     {1, 1, 0, 0, 0, 0},
+			"Python": {2, 1, 1, 0, 0, 0},
+		}},
+		{"literate.lhs", "LiterateHaskell", counts{12, 3, 6, 0, 3, 0}, nil},
+		{"mixed.go", "Go", counts{8, 6, 1, 0, 1, 3}, nil},
+		{"nested.hs", "Haskell", counts{5, 1, 4, 0, 0, 0}, nil},
+		{"nested.rs", "Rust", counts{7, 3, 4, 0, 0, 1}, nil},
+		{"quotes.go", "Go", counts{20, 14, 3, 0, 3, 0}, nil},
+		{"quotes.yaml", "Yaml", counts{4, 2, 2, 0, 0, 0}, nil},
+		{"raw.go", "Go", counts{14, 11, 1, 0, 2, 0}, nil},
+		{"raw.rs", "Rust", counts{8, 8, 0, 0, 0, 1}, nil},
+		{"template.js", "JavaScript", counts{5, 5, 0, 0, 0, 0}, nil},
+		{"test.rs", "Rust", counts{15, 6, 6, 0, 3, 0}, nil},
+	}
+	config := testConfig(t, MixedCode)
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			report := parseFile(FileEntry{Path: filepath.Join("testdata", tt.file)}, config)
+			if report.Reason != "" {
+				t.Fatalf("skipped: %s %s", report.Reason, report.Error)
+			}
+			if report.Language != tt.language {
+				t.Errorf("language = %s, want %s", report.Language, tt.language)
+			}
+			if got := countsOf(report.Stats); got != tt.want {
+				t.Errorf("lines, code, comments, docs, blanks, mixed = %v, want %v", got, tt.want)
+			}
+			if len(report.Stats.Children) != len(tt.children) {
+				t.Errorf("children = %v, want %v", report.Stats.Children.SortedKeys(), tt.children)
+			}
+			for child, want := range tt.children {
+				if got := countsOf(report.Stats.Children[child]); got != want {
+					t.Errorf("%s: lines, code, comments, docs, blanks, mixed = %v, want %v", child, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestParseMixedBoth counts the mixed lines as code and as comments
+func TestParseMixedBoth(t *testing.T) {
+	config := testConfig(t, MixedBoth)
+	report := parseFile(FileEntry{Path: filepath.Join("testdata", "mixed.go")}, config)
+	want := counts{8, 6, 4, 0, 1, 3}
+	if got := countsOf(report.Stats); got != want {
+		t.Errorf("lines, code, comments, docs, blanks, mixed = %v, want %v", got, want)
+	}
+}
@@ -31,15 +31,51 @@ type FileStats struct {
 	Lines    int
 	Code     int
 	Comments int
+	Docs     int // documentation comments and doc strings
 	Blanks   int
+	Mixed    int // lines with code and comments, counted as code too
+	Children FileStatsMap `json:",omitempty"` // embedded languages
 }
 
 type FileStatsMap map[string]FileStats
 
+// SkipReason tells why a file was not counted or was counted as skipped
+type SkipReason string
+
+const (
+	SkipPermissionDenied SkipReason = "permission denied"
+	SkipBinary           SkipReason = "binary"
+	SkipTooLarge         SkipReason = "too large"
+	SkipReadError        SkipReason = "read error"
+	SkipUnknownLanguage  SkipReason = "unknown language"
+	SkipFiltered         SkipReason = "ignored by filter"
+)
+
+type SkippedFile struct {
+	Path  string
+	Error string `json:",omitempty"`
+}
+
+// SkippedFilesMap lists the skipped files by reason
+type SkippedFilesMap map[SkipReason][]SkippedFile
+
+// FileReport holds the stats of a single file
+type FileReport struct {
+	Path     string
+	Language string
+	Detector string     `json:",omitempty"` // what told the language
+	Stats    FileStats
+	Reason   SkipReason `json:",omitempty"` // why the file was skipped
+	Error    string     `json:",omitempty"`
+}
+
 type SummaryStatsMap struct {
 	Totals            FileStats
 	Stats             FileStatsMap
 	MostUsedLanguage  string
+	Files             []FileReport `json:",omitempty"`
+	SkippedFiles      SkippedFilesMap `json:",omitempty"`
+	ShowMixed         bool `json:"-"` // table and csv Mixed column
 }
 
 // Add adds values from another Stats to this one
@@ -49,18 +85,63 @@ func (s *FileStats) Add(other FileStats) {
 	s.Lines += other.Lines
 	s.Code += other.Code
 	s.Comments += other.Comments
+	s.Docs += other.Docs
 	s.Blanks += other.Blanks
+	s.Mixed += other.Mixed
+	if len(other.Children) > 0 {
+		if s.Children == nil {
+			s.Children = FileStatsMap{}
+		}
+		s.Children.Merge(other.Children)
+	}
+}
+
+// AddChild adds the stats of a block of an embedded language: Files counts
+// the host files containing the language
+func (s *FileStats) AddChild(language string, child FileStats) {
+	if language == "" || child.Lines == 0 {
+		return
+	}
+	if _, exists := s.Children[language]; exists {
+		child.Files = 0
+	} else {
+		child.Files = 1
+	}
+	s.Add(FileStats{Children: FileStatsMap{language: child}})
 }
 
 func (sm FileStatsMap) Merge(other FileStatsMap) {
 	for k, v2 := range other {
-		if v1, exists := sm[k]; exists {
-			v1.Add(v2)
-			sm[k] = v1
-		} else {
-			sm[k] = v2
-		}
+		// Add copies the children, the map of other is not shared
+		v1 := sm[k]
+		v1.Add(v2)
+		sm[k] = v1
+	}
+}
+
+// SortedKeys returns the languages sorted by name
+func (sm FileStatsMap) SortedKeys() []string {
+	keys := make([]string, 0, len(sm))
+	for k := range sm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (sm SkippedFilesMap) Add(report FileReport) {
+	sm[report.Reason] = append(sm[report.Reason], SkippedFile{report.Path, report.Error})
+}
+
+// Sort sorts reasons and files by name for a stable output
+func (sm SkippedFilesMap) Sort() []SkipReason {
+	reasons := make([]SkipReason, 0, len(sm))
+	for reason, files := range sm {
+		reasons = append(reasons, reason)
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
 	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+	return reasons
 }
 
 func BuildSummaryStats(data FileStatsMap) SummaryStatsMap {
@@ -75,8 +156,19 @@ func BuildSummaryStats(data FileStatsMap) SummaryStatsMap {
 		total.Lines += stats.Lines
 		total.Code += stats.Code
 		total.Comments += stats.Comments
+		total.Docs += stats.Docs
 		total.Blanks += stats.Blanks
-		
+		total.Mixed += stats.Mixed
+		// lines of embedded languages, their files are the host ones
+		for _, child := range stats.Children {
+			total.Lines += child.Lines
+			total.Code += child.Code
+			total.Comments += child.Comments
+			total.Docs += child.Docs
+			total.Blanks += child.Blanks
+			total.Mixed += child.Mixed
+		}
+
 		if strings.HasPrefix(lang, "ext_") {
 			continue
 		}
@@ -92,84 +184,260 @@ func BuildSummaryStats(data FileStatsMap) SummaryStatsMap {
 	return result	
 }
 
+// SortFileReports sorts the reports by column (path, lang, skipped, lines,
+// code, comments, docs, blanks, mixed): names ascending, counters descending.
+// With top > 0 only the first top reports are returned
+func SortFileReports(reports []FileReport, column string, top int) ([]FileReport, error) {
+	counters := map[string]func(FileStats) int{
+		"skipped":  func(s FileStats) int { return s.Skipped },
+		"lines":    func(s FileStats) int { return s.Lines },
+		"code":     func(s FileStats) int { return s.Code },
+		"comments": func(s FileStats) int { return s.Comments },
+		"docs":     func(s FileStats) int { return s.Docs },
+		"blanks":   func(s FileStats) int { return s.Blanks },
+		"mixed":    func(s FileStats) int { return s.Mixed },
+	}
+
+	var less func(a, b FileReport) bool
+	switch column = strings.ToLower(column); column {
+	case "path", "file":
+		less = func(a, b FileReport) bool { return a.Path < b.Path }
+	case "lang", "language":
+		less = func(a, b FileReport) bool {
+			if a.Language != b.Language {
+				return a.Language < b.Language
+			}
+			return a.Path < b.Path
+		}
+	default:
+		counter, ok := counters[column]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort column '%s'", column)
+		}
+		less = func(a, b FileReport) bool {
+			if counter(a.Stats) != counter(b.Stats) {
+				return counter(a.Stats) > counter(b.Stats)
+			}
+			return a.Path < b.Path
+		}
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool { return less(reports[i], reports[j]) })
+	if top > 0 && top < len(reports) {
+		reports = reports[:top]
+	}
+	return reports, nil
+}
+
 func PrintSummaryStatsTable(summary SummaryStatsMap) {
+	if summary.Files != nil {
+		PrintFileReportsTable(summary)
+		return
+	}
+
 	//var buf bytes.Buffer
 	//table := tablewriter.NewWriter(&buf)
 	data  := summary.Stats
 	total := summary.Totals
+	mixed := summary.ShowMixed
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Lang", "Files", "Skipped", "Lines", "Code", "Comments", "Blanks"})
+	table.SetHeader(append([]string{"Lang", "Files", "Skipped"}, countersHeader(mixed)...))
 
-	table.SetColumnAlignment([]int{
+	table.SetColumnAlignment(append([]int{
 		tablewriter.ALIGN_LEFT,  // Lang
 		tablewriter.ALIGN_RIGHT, // Files
 		tablewriter.ALIGN_RIGHT, // Skipped
-		tablewriter.ALIGN_RIGHT, // Lines
-		tablewriter.ALIGN_RIGHT, // Code
-		tablewriter.ALIGN_RIGHT, // Comments
-		tablewriter.ALIGN_RIGHT, // Blanks
-	})
+	}, countersAlignment(mixed)...))
 
 	// Sort keys for consistent output
-	keys := make([]string, 0, len(data))
-	for k := range data {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	for _, k := range keys {
+	for _, k := range data.SortedKeys() {
 		v := data[k]
-		row := []string{
-			k,
-			fmt.Sprint(v.Files),
-			fmt.Sprint(v.Skipped),
-			fmt.Sprint(v.Lines),
-			fmt.Sprint(v.Code),
-			fmt.Sprint(v.Comments),
-			fmt.Sprint(v.Blanks),
+		table.Append(statsRecord(k, v, mixed))
+		// embedded languages below their host
+		for _, child := range v.Children.SortedKeys() {
+			table.Append(statsRecord(" |- "+child, v.Children[child], mixed))
 		}
-		table.Append(row)
 	}
 
-	table.SetFooter([]string{
+	table.SetFooter(append([]string{
 		"TOTAL",
 		fmt.Sprint(total.Files),
 		fmt.Sprint(total.Skipped),
-		fmt.Sprint(total.Lines),
-		fmt.Sprint(total.Code),
-		fmt.Sprint(total.Comments),
-		fmt.Sprint(total.Blanks),
-	})
+	}, countersRecord(total, mixed)...))
+	table.SetFooterAlignment(tablewriter.ALIGN_RIGHT)
+	
+	table.Render()
+}
+
+func PrintFileReportsTable(summary SummaryStatsMap) {
+	total := summary.Totals
+	mixed := summary.ShowMixed
+	table := tablewriter.NewWriter(os.Stdout)
+	header := append([]string{"File", "Lang", "Skipped"}, countersHeader(mixed)...)
+	table.SetHeader(append(header, "Detector", "Reason"))
+
+	alignment := append([]int{
+		tablewriter.ALIGN_LEFT,  // File
+		tablewriter.ALIGN_LEFT,  // Lang
+		tablewriter.ALIGN_RIGHT, // Skipped
+	}, countersAlignment(mixed)...)
+	table.SetColumnAlignment(append(alignment, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT)) // Detector, Reason
+	table.SetAutoWrapText(false)
+
+	for _, r := range summary.Files {
+		table.Append(fileRecord(r.Path, r.Language, r.Detector, r.Stats, r.Reason, mixed))
+		for _, child := range r.Stats.Children.SortedKeys() {
+			table.Append(fileRecord("", " |- "+child, "", r.Stats.Children[child], "", mixed))
+		}
+	}
+
+	footer := append([]string{
+		"TOTAL",
+		fmt.Sprintf("%d files", total.Files),
+		fmt.Sprint(total.Skipped),
+	}, countersRecord(total, mixed)...)
+	table.SetFooter(append(footer, "", ""))
 	table.SetFooterAlignment(tablewriter.ALIGN_RIGHT)
 	
 	table.Render()
 }
 
 func PrintSummaryStatsCsv(summary SummaryStatsMap) error {
+	if summary.Files != nil {
+		return PrintFileReportsCsv(summary)
+	}
+
 	data  := summary.Stats
+	mixed := summary.ShowMixed
 	writer := csv.NewWriter(os.Stdout)
 	defer writer.Flush()
 
 	// Write header
-	header := []string{"Path", "Files", "Skipped", "Lines", "Code", "Comments", "Blanks"}
+	header := append([]string{"Path", "Files", "Skipped"}, countersHeader(mixed)...)
 	if err := writer.Write(header); err != nil {
 		return fmt.Errorf("error writing header: %w", err)
 	}
 
 	// Write data rows
 	for path, stats := range data {
-		record := []string{
-			path,
-			fmt.Sprint(stats.Files),
-			fmt.Sprint(stats.Skipped),
-			fmt.Sprint(stats.Lines),
-			fmt.Sprint(stats.Code),
-			fmt.Sprint(stats.Comments),
-			fmt.Sprint(stats.Blanks),
+		if err := writer.Write(statsRecord(path, stats, mixed)); err != nil {
+			return fmt.Errorf("error writing record: %w", err)
 		}
-		if err := writer.Write(record); err != nil {
+		// embedded languages as "host/child"
+		for child, childStats := range stats.Children {
+			if err := writer.Write(statsRecord(path+"/"+child, childStats, mixed)); err != nil {
+				return fmt.Errorf("error writing record: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// countersHeader returns the header of the line counters, with the Mixed
+// column if mixed is set
+func countersHeader(mixed bool) []string {
+	header := []string{"Lines", "Code", "Comments", "Docs", "Blanks"}
+	if mixed {
+		header = append(header, "Mixed")
+	}
+	return header
+}
+
+func countersAlignment(mixed bool) []int {
+	alignment := make([]int, len(countersHeader(mixed)))
+	for i := range alignment {
+		alignment[i] = tablewriter.ALIGN_RIGHT
+	}
+	return alignment
+}
+
+func countersRecord(stats FileStats, mixed bool) []string {
+	record := []string{
+		fmt.Sprint(stats.Lines),
+		fmt.Sprint(stats.Code),
+		fmt.Sprint(stats.Comments),
+		fmt.Sprint(stats.Docs),
+		fmt.Sprint(stats.Blanks),
+	}
+	if mixed {
+		record = append(record, fmt.Sprint(stats.Mixed))
+	}
+	return record
+}
+
+func statsRecord(name string, stats FileStats, mixed bool) []string {
+	record := []string{
+		name,
+		fmt.Sprint(stats.Files),
+		fmt.Sprint(stats.Skipped),
+	}
+	return append(record, countersRecord(stats, mixed)...)
+}
+
+func PrintFileReportsCsv(summary SummaryStatsMap) error {
+	mixed := summary.ShowMixed
+	writer := csv.NewWriter(os.Stdout)
+	defer writer.Flush()
+
+	// Write header
+	header := append([]string{"Path", "Lang", "Skipped"}, countersHeader(mixed)...)
+	if err := writer.Write(append(header, "Detector", "Reason")); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+
+	// Write data rows
+	for _, r := range summary.Files {
+		if err := writer.Write(fileRecord(r.Path, r.Language, r.Detector, r.Stats, r.Reason, mixed)); err != nil {
 			return fmt.Errorf("error writing record: %w", err)
 		}
+		// embedded languages as "host/child"
+		for _, child := range r.Stats.Children.SortedKeys() {
+			record := fileRecord(r.Path, r.Language+"/"+child, "", r.Stats.Children[child], "", mixed)
+			if err := writer.Write(record); err != nil {
+				return fmt.Errorf("error writing record: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func fileRecord(path string, language string, detector string, stats FileStats, reason SkipReason, mixed bool) []string {
+	record := []string{
+		path,
+		language,
+		fmt.Sprint(stats.Skipped),
+	}
+	record = append(record, countersRecord(stats, mixed)...)
+	return append(record, detector, string(reason))
+}
+
+func PrintSkippedFilesTable(summary SummaryStatsMap) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Reason", "File", "Error"})
+	table.SetAutoWrapText(false)
+	table.SetAutoMergeCellsByColumnIndex([]int{0}) // Reason
+
+	for _, reason := range summary.SkippedFiles.Sort() {
+		for _, f := range summary.SkippedFiles[reason] {
+			table.Append([]string{string(reason), f.Path, f.Error})
+		}
+	}
+	table.Render()
+}
+
+func PrintSkippedFilesCsv(summary SummaryStatsMap) error {
+	writer := csv.NewWriter(os.Stdout)
+	defer writer.Flush()
+
+	if err := writer.Write([]string{"Reason", "Path", "Error"}); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+	for _, reason := range summary.SkippedFiles.Sort() {
+		for _, f := range summary.SkippedFiles[reason] {
+			if err := writer.Write([]string{string(reason), f.Path, f.Error}); err != nil {
+				return fmt.Errorf("error writing record: %w", err)
+			}
+		}
 	}
 	return nil
 }
@@ -177,8 +445,7 @@ func PrintSummaryStatsCsv(summary SummaryStatsMap) error {
 func PrintSummaryStatsJson(summary SummaryStatsMap) error {
 	jsonBytes, err := json.Marshal(summary)
 	if err != nil {
-		fmt.Errorf("Error: %s", err)
-		return err
+		return fmt.Errorf("error marshalling json: %w", err)
 	}
 	jsonStr := string(jsonBytes)
 	fmt.Println(jsonStr)
@@ -0,0 +1,12 @@
+/* header
+   comment */ int x = 1;
+/* a
+ */ return x; /* b
+still b */ /* c */
+/* d
+ */ /* e
+ */
+/**
+ * docs */ int y;
+int z; /* f
+g */ // h
@@ -0,0 +1,5 @@
+--[[
+block
+]]
+print(1)
+-- line
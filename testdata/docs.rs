@@ -0,0 +1,11 @@
+//! crate docs
+/// fn docs
+/** block
+ * docs */
+/**/ fn a() {}
+//// not docs
+/* plain */
+/*! inner
+   /* nested */
+   still docs */
+fn main() {} // x
@@ -0,0 +1,8 @@
+package main
+
+func main() {
+	x := 1 // reset
+	/* a */ foo()
+	y := "/*" /* c */
+	/* only */
+}
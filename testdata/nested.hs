@@ -0,0 +1,5 @@
+{- outer
+   {- inner -}
+   still a comment
+-}
+main = print 1
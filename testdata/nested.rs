@@ -0,0 +1,7 @@
+/* outer
+   /* inner */
+   still a comment
+*/
+fn main() {
+    /* /* */ still comment */ let x = 1;
+}
@@ -0,0 +1,20 @@
+package main
+
+func main() {
+	url := "http://example.com/*"
+	glob := "src/**/*.go"
+	escaped := "a \" /* b"
+
+	// comment
+	println(url, glob, escaped)
+}
+
+func quote(c byte) bool {
+	if c == '"' || c == '\'' {
+		// a char literal does not start a string
+		return true
+	}
+	msg := "unterminated
+	// a stray quote ends with the line
+	return false
+}
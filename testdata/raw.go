@@ -0,0 +1,14 @@
+package main
+
+var re = `C:\path\`
+var tmpl = `
+/* not a comment
+// neither
+`
+
+func main() {
+	if tmpl[0] == '`' {
+		// a backtick rune does not start a raw string
+		println(re)
+	}
+}
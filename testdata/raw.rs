@@ -0,0 +1,8 @@
+fn main() {
+    let re = r"//not a comment\";
+    let sql = r###"
+        /* not a comment
+        "## still raw
+    "###;
+    let x = 1; // comment
+}
@@ -0,0 +1,5 @@
+const q = `
+  SELECT * FROM t /* x
+`;
+const y = 1;
+const z = 2;
@@ -1,7 +1,7 @@
 
 /*
 This comment is only three lines long
-//*/
+// */
 
 pub fn main() {
 	let a = 5;